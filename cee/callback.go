@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	callback_attempts = 6
+	callback_timeout  = 10 * time.Second
+)
+
+var callback_first_backoff = time.Second
+
+var errInternalCallbackAddress = errors.New("callback address is internal")
+
+// The router refuses a callback URL resolving to an internal address, cee
+// refuses to connect to one too, since the host may resolve to another address
+// by now. Hosts of CALLBACK_ALLOWED_HOSTS, which the router must be given too,
+// are trusted and may be internal.
+var callback_allowed_hosts = Parse_Allowed_Hosts(os.Getenv("CALLBACK_ALLOWED_HOSTS"))
+
+var (
+	callback_client         = New_Callback_Client(true)
+	allowed_callback_client = New_Callback_Client(false)
+)
+
+// New_Callback_Client returns a client that does not follow redirects, which
+// would go around the checks of the router, nor use a proxy, which would
+// connect to the callback itself.
+func New_Callback_Client(refuse_internal bool) *http.Client {
+	dialer := &net.Dialer{Timeout: callback_timeout}
+	if refuse_internal {
+		dialer.Control = Refuse_Internal_Address
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   callback_timeout,
+		Transport: transport,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Refuse_Internal_Address is called with the resolved address right before
+// connecting.
+func Refuse_Internal_Address(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || Is_Internal_IP(ip) {
+		return fmt.Errorf("%w: %s", errInternalCallbackAddress, host)
+	}
+	return nil
+}
+
+func Is_Internal_IP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+func Parse_Allowed_Hosts(value string) []string {
+	hosts := []string{}
+	for _, host := range strings.Split(value, ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+func Is_Allowed_Host(host string, allowed_hosts []string) bool {
+	for _, allowed := range allowed_hosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Callback is where the router asked the result of a submission to be posted.
+// It is kept apart from the submission so the secret is never returned by
+// retrieve_submission.
+type Callback struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+func Callback_Key(submission_id string) string {
+	return "callback:" + submission_id
+}
+
+// Notify_Callback posts the judged submission to its callback, if it has one.
+func Notify_Callback(redis_client *redis.Client, submission Submission) {
+	ctx := context.Background()
+	value, err := redis_client.Get(ctx, Callback_Key(submission.SubmissionID)).Result()
+	if err == redis.Nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get callback of submission %s: %v", submission.SubmissionID, err)
+		return
+	}
+	var callback Callback
+	if err := json.Unmarshal([]byte(value), &callback); err != nil {
+		log.Printf("Failed to parse callback of submission %s: %v", submission.SubmissionID, err)
+		return
+	}
+	body, err := json.Marshal(submission)
+	if err != nil {
+		log.Printf("Failed to encode submission %s for its callback: %v", submission.SubmissionID, err)
+		return
+	}
+	if Deliver_Callback(callback, submission.SubmissionID, body) == nil {
+		redis_client.Del(ctx, Callback_Key(submission.SubmissionID))
+	}
+}
+
+// Deliver_Callback posts body to the callback. The X-Algorint-Signature
+// header is the HMAC-SHA256 with the secret of the X-Algorint-Timestamp header,
+// a dot and the body, so receivers can refuse an old request being replayed.
+// Failed attempts are retried with exponential backoff, except for client
+// errors that a retry cannot fix.
+func Deliver_Callback(callback Callback, submission_id string, body []byte) error {
+	backoff := callback_first_backoff
+	var err error
+	for attempt := 1; attempt <= callback_attempts; attempt++ {
+		var retry bool
+		retry, err = Post_Callback(callback, submission_id, body)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Callback of submission %s failed, attempt %d: %v", submission_id, attempt, err)
+		if !retry {
+			return err
+		}
+		if attempt < callback_attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// Post_Callback makes one attempt and tells whether a failure is worth
+// retrying.
+func Post_Callback(callback Callback, submission_id string, body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, callback.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Algorint-Submission-Id", submission_id)
+	if callback.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set("X-Algorint-Timestamp", timestamp)
+		request.Header.Set("X-Algorint-Signature", "sha256="+Sign_Callback(callback.Secret, timestamp, body))
+	}
+	client := callback_client
+	if Is_Allowed_Host(strings.ToLower(request.URL.Hostname()), callback_allowed_hosts) {
+		client = allowed_callback_client
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return !errors.Is(err, errInternalCallbackAddress), err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("callback returned status %d", response.StatusCode)
+}
+
+func Sign_Callback(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Allow_Test_Callbacks trusts the address of httptest servers, which is
+// internal.
+func Allow_Test_Callbacks(t *testing.T) {
+	allowed_hosts := callback_allowed_hosts
+	callback_allowed_hosts = []string{"127.0.0.1"}
+	t.Cleanup(func() { callback_allowed_hosts = allowed_hosts })
+}
+
+func TestDeliverCallbackSignature(t *testing.T) {
+	Allow_Test_Callbacks(t)
+	body := []byte(`{"submission_id":"42","verdict":"AC"}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ := io.ReadAll(r.Body)
+		if string(received) != string(body) {
+			t.Errorf("body = %s, want %s", received, body)
+		}
+		if id := r.Header.Get("X-Algorint-Submission-Id"); id != "42" {
+			t.Errorf("submission id header = %q, want 42", id)
+		}
+		timestamp := r.Header.Get("X-Algorint-Timestamp")
+		sent, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+			t.Errorf("timestamp header = %q, want the current unix time", timestamp)
+		}
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(received)
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if signature := r.Header.Get("X-Algorint-Signature"); signature != want {
+			t.Errorf("signature header = %q, want %q", signature, want)
+		}
+	}))
+	defer server.Close()
+
+	if err := Deliver_Callback(Callback{URL: server.URL, Secret: "secret"}, "42", body); err != nil {
+		t.Fatalf("Deliver_Callback() = %v", err)
+	}
+}
+
+func TestDeliverCallbackWithoutSecret(t *testing.T) {
+	Allow_Test_Callbacks(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range []string{"X-Algorint-Timestamp", "X-Algorint-Signature"} {
+			if value := r.Header.Get(header); value != "" {
+				t.Errorf("%s = %q, want none without a secret", header, value)
+			}
+		}
+	}))
+	defer server.Close()
+
+	if err := Deliver_Callback(Callback{URL: server.URL}, "42", []byte("{}")); err != nil {
+		t.Fatalf("Deliver_Callback() = %v", err)
+	}
+}
+
+func TestDeliverCallbackRetries(t *testing.T) {
+	Allow_Test_Callbacks(t)
+	first_backoff := callback_first_backoff
+	callback_first_backoff = time.Millisecond
+	defer func() { callback_first_backoff = first_backoff }()
+
+	tests := []struct {
+		name          string
+		statuses      []int
+		want_attempts int
+		want_err      bool
+	}{
+		{"accepted at once", []int{http.StatusOK}, 1, false},
+		{"retried after server errors", []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusNoContent}, 3, false},
+		{"retried when rate limited", []int{http.StatusTooManyRequests, http.StatusOK}, 2, false},
+		{"client error is not retried", []int{http.StatusBadRequest}, 1, true},
+		{"gives up after every attempt", []int{http.StatusServiceUnavailable}, callback_attempts, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				status := test.statuses[len(test.statuses)-1]
+				if attempts < len(test.statuses) {
+					status = test.statuses[attempts]
+				}
+				attempts++
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			err := Deliver_Callback(Callback{URL: server.URL, Secret: "secret"}, "42", []byte("{}"))
+			if (err != nil) != test.want_err {
+				t.Errorf("Deliver_Callback() = %v, want error %v", err, test.want_err)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if attempts != test.want_attempts {
+				t.Errorf("attempts = %d, want %d", attempts, test.want_attempts)
+			}
+		})
+	}
+}
+
+func TestDeliverCallbackRefusesInternalAddress(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+	}))
+	defer server.Close()
+
+	err := Deliver_Callback(Callback{URL: server.URL}, "42", []byte("{}"))
+	if !errors.Is(err, errInternalCallbackAddress) {
+		t.Errorf("Deliver_Callback() = %v, want %v", err, errInternalCallbackAddress)
+	}
+	if attempts != 0 {
+		t.Errorf("internal server got %d requests, want none", attempts)
+	}
+}
+
+func TestDeliverCallbackDoesNotFollowRedirects(t *testing.T) {
+	Allow_Test_Callbacks(t)
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	if err := Deliver_Callback(Callback{URL: server.URL}, "42", []byte("{}")); err == nil {
+		t.Errorf("Deliver_Callback() = nil, want the redirect to fail")
+	}
+	if redirected {
+		t.Errorf("redirect was followed")
+	}
+}
+
+func TestRefuseInternalAddress(t *testing.T) {
+	tests := []struct {
+		address  string
+		want_err bool
+	}{
+		{"93.184.216.34:443", false},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", false},
+		{"127.0.0.1:80", true},
+		{"10.0.0.1:80", true},
+		{"172.16.3.4:80", true},
+		{"192.168.1.1:80", true},
+		{"169.254.169.254:80", true},
+		{"0.0.0.0:80", true},
+		{"[::1]:80", true},
+		{"[fd00::1]:80", true},
+		{"[::ffff:10.0.0.1]:80", true},
+	}
+	for _, test := range tests {
+		err := Refuse_Internal_Address("tcp", test.address, nil)
+		if (err != nil) != test.want_err {
+			t.Errorf("Refuse_Internal_Address(%s) = %v, want error %v", test.address, err, test.want_err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/docker/docker/client"
+)
+
+// Code_Variant is the code of a submission after the Replace rules of a test
+// case were applied. Test cases with the same rules share one variant, which
+// is prepared and compiled only once in its own volume.
+type Code_Variant struct {
+	Code        []byte
+	Volume_Name string
+	// Verdict and Message are set when the variant could not be prepared, in
+	// which case every test case using it gets them without running.
+	Verdict Verdict
+	Message string
+	Output  string
+}
+
+// Build_Code_Variants applies the Replace rules of every test case to the
+// code and returns the distinct variants with, for every test case, the index
+// of the variant it uses.
+func Build_Code_Variants(submission Submission) ([]*Code_Variant, []int, error) {
+	code, err := base64.StdEncoding.DecodeString(submission.Code)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode code: %v", err)
+	}
+	variants := []*Code_Variant{}
+	variant_of := make([]int, len(submission.Tests))
+	seen := map[string]int{}
+	for index, test := range submission.Tests {
+		test_code := code
+		for _, replaces := range test.Replace {
+			from, err := base64.StdEncoding.DecodeString(replaces.From)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to decode replace from of test case %d: %v", index, err)
+			}
+			to, err := base64.StdEncoding.DecodeString(replaces.To)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to decode replace to of test case %d: %v", index, err)
+			}
+			test_code = bytes.Replace(test_code, from, to, -1)
+		}
+		variant, ok := seen[string(test_code)]
+		if !ok {
+			variant = len(variants)
+			seen[string(test_code)] = variant
+			variants = append(variants, &Code_Variant{
+				Code:        test_code,
+				Volume_Name: fmt.Sprintf("submission-%s-%d", submission.SubmissionID, variant),
+			})
+		}
+		variant_of[index] = variant
+	}
+	return variants, variant_of, nil
+}
+
+// Prepare_Code_Variants places every variant in its volume and compiles it
+// when the language needs it, all variants in parallel. A variant whose slot
+// was not given before ctx is done is skipped. Remove_Code_Variants has to be
+// called once the test cases are done.
+func Prepare_Code_Variants(ctx context.Context, cli *client.Client, language string, variants []*Code_Variant, submission_id string) {
+	var wg sync.WaitGroup
+	for _, variant := range variants {
+		wg.Add(1)
+		go func(variant *Code_Variant) {
+			defer wg.Done()
+			log.Printf("Prepare %s for submission %v", variant.Volume_Name, submission_id)
+			slot, err := sandbox_slots.Acquire(ctx)
+			if err != nil {
+				variant.Verdict = Verdict_Skipped
+				return
+			}
+			defer sandbox_slots.Release(slot)
+			compile_result, err := Prepare_Program(context.Background(), cli, submission_id, language, variant.Code, variant.Volume_Name)
+			if err != nil {
+				log.Printf("Failed to prepare %s: %v", variant.Volume_Name, err)
+				variant.Verdict = Verdict_System_Error
+				variant.Message = "Sandbox error, try to run again"
+				return
+			}
+			variant.Verdict, variant.Message = Compile_Verdict(compile_result)
+			if variant.Verdict != "" {
+				variant.Output = compile_result.Stdout + compile_result.Stderr
+			}
+		}(variant)
+	}
+	wg.Wait()
+}
+
+func Remove_Code_Variants(cli *client.Client, variants []*Code_Variant) {
+	for _, variant := range variants {
+		Remove_Volume(cli, variant.Volume_Name)
+	}
+}
+
+// Compile_Verdict maps the result of a compile container to a verdict. An
+// empty verdict means the code compiled.
+func Compile_Verdict(compile_result Program_Result) (Verdict, string) {
+	switch {
+	case compile_result.Timed_Out:
+		return Verdict_Compile_Error, "Compile Time Limit Exceeded"
+	case compile_result.OOM_Killed:
+		return Verdict_Compile_Error, "Compile Memory Limit Exceeded"
+	case compile_result.Exit_Code == 127:
+		return Verdict_Compile_Error, "Compile Error, Invalid Command"
+	case compile_result.Exit_Code != 0:
+		return Verdict_Compile_Error, ""
+	default:
+		return "", ""
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// CPUs given to a sandbox when neither its test case nor its language set it
+const default_cpus = 1.0
+
+// Messages of a TLE, telling which time limit was exceeded. Both times are in
+// the usage of the test case.
+const (
+	Message_Cpu_Time_Exceeded  = "CPU time limit exceeded"
+	Message_Wall_Time_Exceeded = "Wall time limit exceeded"
+)
+
+// Exit codes of a program killed by a signal are 128 plus the signal.
+const (
+	exit_code_sigkill = 128 + int64(syscall.SIGKILL)
+	exit_code_sigxcpu = 128 + int64(syscall.SIGXCPU)
+)
+
+// With SANDBOX_CPU_PINNING=true every test case using at most one CPU is
+// pinned to the CPU of its slot, so test cases running at once do not share a
+// core.
+var cpu_pinning = os.Getenv("SANDBOX_CPU_PINNING") == "true"
+
+// Cpu_Limit is the CPU given to the sandbox of a test case. Cpus is a share of
+// the CPUs, e.g. 0.5, Cpuset the CPUs it is pinned to, empty when it is not,
+// and Time_Limit its CPU time limit.
+type Cpu_Limit struct {
+	Cpus       float64
+	Cpuset     string
+	Time_Limit time.Duration
+}
+
+// Test_Cpu_Limit resolves the CPU of a test case running in slot, the test
+// case limits override the ones of the language. The CPU time limit defaults
+// to the wall time limit.
+func Test_Cpu_Limit(language Language, limits Test_Limits, time_limit int, slot int) Cpu_Limit {
+	cpus := limits.Cpus
+	if cpus <= 0 {
+		cpus = Language_Cpus(language)
+	}
+	cpus = Host_Cpus(cpus)
+	cpu_time_limit := limits.CpuTimeLimit
+	if cpu_time_limit <= 0 {
+		cpu_time_limit = language.CpuTimeLimit
+	}
+	if cpu_time_limit <= 0 {
+		cpu_time_limit = time_limit
+	}
+	return Cpu_Limit{
+		Cpus:       cpus,
+		Cpuset:     Slot_Cpuset(slot, cpus),
+		Time_Limit: time.Duration(cpu_time_limit) * time.Second,
+	}
+}
+
+func Language_Cpus(language Language) float64 {
+	if language.Cpus > 0 {
+		return Host_Cpus(language.Cpus)
+	}
+	return default_cpus
+}
+
+// Host_Cpus clamps cpus to the CPUs of the host, Docker refuses more. The
+// language config is shared by nodes that may have fewer CPUs than it sets.
+func Host_Cpus(cpus float64) float64 {
+	if cpus > float64(runtime.NumCPU()) {
+		return float64(runtime.NumCPU())
+	}
+	return cpus
+}
+
+// Slot_Cpuset returns the CPU a test case in slot is pinned to, empty when
+// pinning is off or the test case needs more than one CPU.
+func Slot_Cpuset(slot int, cpus float64) string {
+	if !cpu_pinning || slot < 0 || cpus > 1 {
+		return ""
+	}
+	return strconv.Itoa(slot % runtime.NumCPU())
+}
+
+// Apply sets the CPU quota and pinning of resources.
+func (limit Cpu_Limit) Apply(resources *container.Resources) {
+	resources.NanoCPUs = int64(limit.Cpus * 1e9)
+	resources.CpusetCpus = limit.Cpuset
+}
+
+// Apply_Ulimit sets the RLIMIT_CPU enforcing the CPU time limit, which Docker
+// only sets when it creates a container. The kernel sends SIGXCPU once a
+// process used the limit, and SIGKILL a second later to one ignoring SIGXCPU.
+// Each process is limited on its own, the CPU quota and the wall time limit
+// bound the processes of a test case together.
+func (limit Cpu_Limit) Apply_Ulimit(resources *container.Resources) {
+	if limit.Time_Limit <= 0 {
+		return
+	}
+	seconds := int64(math.Ceil(limit.Time_Limit.Seconds()))
+	resources.Ulimits = append(resources.Ulimits, &units.Ulimit{
+		Name: "cpu",
+		Soft: seconds,
+		Hard: seconds + 1,
+	})
+}
+
+// Killed tells whether the RLIMIT_CPU of the limit killed a program that
+// exited with exit_code. A SIGKILL may come from the OOM killer too, it only
+// counts when the program was not OOM killed and ran long enough to use its CPU
+// time with its CPU quota.
+func (limit Cpu_Limit) Killed(exit_code int64, oom_killed bool, usage Usage) bool {
+	if limit.Time_Limit <= 0 || oom_killed {
+		return false
+	}
+	switch exit_code {
+	case exit_code_sigxcpu:
+		return true
+	case exit_code_sigkill:
+		wall_time := time.Duration(usage.WallTime) * time.Millisecond
+		return time.Duration(float64(wall_time)*limit.Cpus) >= limit.Time_Limit
+	default:
+		return false
+	}
+}
+
+func Time_Limit_Result(submission_index int, message string, usage Usage) Execution_Result {
+	return Execution_Result{
+		Submission_Index: submission_index,
+		Verdict:          Verdict_Time_Limit_Exceeded,
+		Message:          message,
+		Usage:            usage,
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestApplyUlimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		time_limit time.Duration
+		want_soft  int64
+	}{
+		{"whole seconds", 2 * time.Second, 2},
+		{"rounded up", 1500 * time.Millisecond, 2},
+		{"no limit", 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resources := container.Resources{}
+			Cpu_Limit{Cpus: 1, Time_Limit: test.time_limit}.Apply_Ulimit(&resources)
+			if test.want_soft == 0 {
+				if len(resources.Ulimits) != 0 {
+					t.Errorf("Ulimits = %+v, want none", resources.Ulimits)
+				}
+				return
+			}
+			if len(resources.Ulimits) != 1 {
+				t.Fatalf("Ulimits = %+v, want the cpu one", resources.Ulimits)
+			}
+			ulimit := resources.Ulimits[0]
+			if ulimit.Name != "cpu" || ulimit.Soft != test.want_soft || ulimit.Hard != test.want_soft+1 {
+				t.Errorf("Ulimit = %+v, want cpu %d:%d", ulimit, test.want_soft, test.want_soft+1)
+			}
+		})
+	}
+}
+
+func TestCpuLimitKilled(t *testing.T) {
+	limit := Cpu_Limit{Cpus: 0.5, Time_Limit: time.Second}
+	tests := []struct {
+		name       string
+		limit      Cpu_Limit
+		exit_code  int64
+		oom_killed bool
+		wall_time  int64
+		want       bool
+	}{
+		{"SIGXCPU", limit, exit_code_sigxcpu, false, 100, true},
+		{"SIGKILL after the CPU time", limit, exit_code_sigkill, false, 2100, true},
+		{"SIGKILL before the CPU time could be used", limit, exit_code_sigkill, false, 1500, false},
+		{"OOM killed", limit, exit_code_sigkill, true, 2100, false},
+		{"runtime error", limit, 1, false, 2100, false},
+		{"no CPU time limit", Cpu_Limit{Cpus: 1}, exit_code_sigxcpu, false, 2100, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.limit.Killed(test.exit_code, test.oom_killed, Usage{WallTime: test.wall_time}); got != test.want {
+				t.Errorf("Killed(%d) = %v, want %v", test.exit_code, got, test.want)
+			}
+		})
+	}
+}
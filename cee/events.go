@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Types of the progress events of a submission. The router publishes queued,
+// cee publishes the others while it handles the submission.
+const (
+	Event_Queued        = "queued"
+	Event_Compiling     = "compiling"
+	Event_Running       = "running"
+	Event_Test_Finished = "test_finished"
+	Event_Judged        = "judged"
+)
+
+// Submission_Event is published on the Redis channel of a submission, which
+// the router streams to the clients. Index is the test case of running and
+// test_finished. The verdict of test_finished is empty when the output still
+// has to be judged.
+type Submission_Event struct {
+	Type         string  `json:"type"`
+	SubmissionID string  `json:"submission_id"`
+	Index        *int    `json:"index,omitempty"`
+	Verdict      Verdict `json:"verdict,omitempty"`
+}
+
+func Submission_Events_Channel(submission_id string) string {
+	return "submission:" + submission_id + ":events"
+}
+
+// Publish_Event publishes a progress event. Events are best effort, a failure
+// is logged and never fails the submission.
+func Publish_Event(redis_client *redis.Client, event Submission_Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event of submission %s: %v", event.Type, event.SubmissionID, err)
+		return
+	}
+	err = redis_client.Publish(context.Background(), Submission_Events_Channel(event.SubmissionID), data).Err()
+	if err != nil {
+		log.Printf("Failed to publish %s event of submission %s: %v", event.Type, event.SubmissionID, err)
+	}
+}
+
+func Test_Event(event_type string, submission_id string, index int, verdict Verdict) Submission_Event {
+	return Submission_Event{
+		Type:         event_type,
+		SubmissionID: submission_id,
+		Index:        &index,
+		Verdict:      verdict,
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
+)
+
+var errQueryLimitExceeded = fmt.Errorf("query limit exceeded")
+
+// Query_Counter forwards the contestant output to the interactor and counts
+// every line as one query. Once the limit is passed nothing else is forwarded
+// and Exceeded is closed.
+type Query_Counter struct {
+	Writer   io.Writer
+	Limit    int
+	Queries  int
+	Exceeded chan struct{}
+	once     sync.Once
+}
+
+func (counter *Query_Counter) Write(data []byte) (int, error) {
+	counter.Queries += bytes.Count(data, []byte("\n"))
+	if counter.Limit > 0 && counter.Queries > counter.Limit {
+		counter.once.Do(func() { close(counter.Exceeded) })
+		return 0, errQueryLimitExceeded
+	}
+	return counter.Writer.Write(data)
+}
+
+// Interactor_Program is the interactor of a submission, prepared once in
+// Volume_Name and shared by its test cases.
+type Interactor_Program struct {
+	Language    string
+	Query_Limit int
+	Volume_Name string
+	// Prepare_Error is set when the interactor failed to prepare or compile
+	Prepare_Error error
+}
+
+// Prepare_Interactor prepares the interactor of a submission in its own
+// volume, which has to be removed once the test cases ran.
+func Prepare_Interactor(ctx context.Context, cli *client.Client, submission_id string, interactor Interactor) *Interactor_Program {
+	program := &Interactor_Program{
+		Language:    interactor.Language,
+		Query_Limit: interactor.QueryLimit,
+		Volume_Name: fmt.Sprintf("interactor-%s", submission_id),
+	}
+	code, err := base64.StdEncoding.DecodeString(interactor.Code)
+	if err != nil {
+		program.Prepare_Error = fmt.Errorf("failed to decode interactor code: %v", err)
+		return program
+	}
+	if err := Prepare_Helper_Program(ctx, cli, submission_id, interactor.Language, code, program.Volume_Name); err != nil {
+		program.Prepare_Error = fmt.Errorf("failed to prepare interactor: %v", err)
+	}
+	return program
+}
+
+// Run_Interactive runs the contestant program, already prepared in
+// contestant_volume, next to the interactor, each in its own container, with
+// the stdout of one piped live to the stdin of the other. The interactor is
+// started as "<execute> /judge/input.txt /judge/expected.txt" and decides the
+// verdict: exit code 0 accepts, 1 rejects and anything else is an interactor
+// failure.
+func Run_Interactive(threading_ctx context.Context, cli *client.Client, contestant_volume string, input string, expected string, language string, interactor *Interactor_Program, time_limit int, mem_limit_mb int, cpu_limit Cpu_Limit, submission_id string, submission_index int, execution_channel chan Execution_Result) {
+	ctx := context.Background()
+	result := Execution_Result{
+		Submission_Index: submission_index,
+	}
+	defer func() {
+		Put_Execution_Result_To_Channel(execution_channel, result)
+	}()
+
+	if interactor.Prepare_Error != nil {
+		result.Verdict = Verdict_System_Error
+		result.Checker_Error = interactor.Prepare_Error.Error()
+		return
+	}
+
+	contestant_language, _ := Get_Language(language)
+	contestant_id, err := Create_Interactive_Container(ctx, cli, submission_id, contestant_language, contestant_language.Run, contestant_volume, true, mem_limit_mb, cpu_limit)
+	if err != nil {
+		log.Printf("Failed to create container for submission %v index %v: %v", submission_id, submission_index, err)
+		result = System_Error_Result(submission_index, "Sandbox error, try to run again")
+		return
+	}
+	defer Remove_Container(cli, contestant_id)
+	interactor_language, _ := Get_Language(interactor.Language)
+	interactor_command := append(append([]string{}, interactor_language.Run...), Judge_File("input.txt"), Judge_File("expected.txt"))
+	interactor_id, err := Create_Interactive_Container(ctx, cli, submission_id, interactor_language, interactor_command, interactor.Volume_Name, false, checker_memory_limit_mb, Cpu_Limit{Cpus: Language_Cpus(interactor_language)})
+	if err != nil {
+		result.Verdict = Verdict_System_Error
+		result.Checker_Error = err.Error()
+		return
+	}
+	defer Remove_Container(cli, interactor_id)
+	files := map[string][]byte{
+		"input.txt":    []byte(input),
+		"expected.txt": []byte(expected),
+	}
+	if err := Copy_Judge_Files(ctx, cli, interactor_id, files); err != nil {
+		result.Verdict = Verdict_System_Error
+		result.Checker_Error = err.Error()
+		return
+	}
+
+	// Attach before starting so no output is lost
+	attach_options := types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: false,
+	}
+	contestant_stream, err := cli.ContainerAttach(ctx, contestant_id, attach_options)
+	if err != nil {
+		log.Printf("Failed to attach submission %v index %v: %v", submission_id, submission_index, err)
+		result = System_Error_Result(submission_index, "Sandbox error, try to run again")
+		return
+	}
+	defer contestant_stream.Close()
+	interactor_stream, err := cli.ContainerAttach(ctx, interactor_id, attach_options)
+	if err != nil {
+		result.Verdict = Verdict_System_Error
+		result.Checker_Error = "failed to attach interactor: " + err.Error()
+		return
+	}
+	defer interactor_stream.Close()
+
+	counter := &Query_Counter{
+		Writer:   interactor_stream.Conn,
+		Limit:    interactor.Query_Limit,
+		Exceeded: make(chan struct{}),
+	}
+	go func() {
+		stdcopy.StdCopy(counter, io.Discard, contestant_stream.Reader)
+		interactor_stream.CloseWrite()
+	}()
+	go func() {
+		stdcopy.StdCopy(contestant_stream.Conn, io.Discard, interactor_stream.Reader)
+		contestant_stream.CloseWrite()
+	}()
+
+	if err = cli.ContainerStart(ctx, interactor_id, types.ContainerStartOptions{}); err != nil {
+		result.Verdict = Verdict_System_Error
+		result.Checker_Error = "failed to start interactor: " + err.Error()
+		return
+	}
+	if err = cli.ContainerStart(ctx, contestant_id, types.ContainerStartOptions{}); err != nil {
+		log.Printf("Failed to start submission %v index %v: %v", submission_id, submission_index, err)
+		result = System_Error_Result(submission_index, "Sandbox error, try to run again")
+		return
+	}
+
+	monitor := Start_Usage_Monitor(cli, contestant_id)
+	defer func() { result.Usage = monitor.Stop() }()
+	time_limit_ctx, cancel := context.WithTimeout(ctx, time.Duration(time_limit)*time.Second)
+	defer cancel()
+	interactor_ctx, cancel_interactor := context.WithTimeout(ctx, time.Duration(time_limit)*time.Second+checker_time_limit)
+	defer cancel_interactor()
+	contestantStatusCh, contestantErrCh := cli.ContainerWait(time_limit_ctx, contestant_id, container.WaitConditionNotRunning)
+	interactorStatusCh, interactorErrCh := cli.ContainerWait(interactor_ctx, interactor_id, container.WaitConditionNotRunning)
+	var contestant_exit_code, interactor_exit_code int64
+	for contestantStatusCh != nil || interactorStatusCh != nil {
+		select {
+		case status := <-contestantStatusCh:
+			contestant_exit_code = status.StatusCode
+			contestantStatusCh, contestantErrCh = nil, nil
+		case status := <-interactorStatusCh:
+			interactor_exit_code = status.StatusCode
+			interactorStatusCh, interactorErrCh = nil, nil
+		case <-contestantErrCh:
+			if time_limit_ctx.Err() != nil {
+				result.Verdict = Verdict_Time_Limit_Exceeded
+				result.Message = Message_Wall_Time_Exceeded
+			} else {
+				result = System_Error_Result(submission_index, "Sandbox error, try to run again")
+			}
+			return
+		case <-interactorErrCh:
+			result.Verdict = Verdict_System_Error
+			result.Checker_Error = "interactor did not finish in time"
+			return
+		case <-counter.Exceeded:
+			result.Verdict = Verdict_Wrong_Answer
+			result.Message = "Query Limit Exceeded"
+			return
+		case <-threading_ctx.Done():
+			result.Verdict = Verdict_Skipped
+			return
+		}
+	}
+
+	contestant_output, err := Get_Container_Output(cli, contestant_id)
+	if err != nil {
+		log.Printf("Failed to get logs of submission %v index %v: %v", submission_id, submission_index, err)
+		result = System_Error_Result(submission_index, "Failed to read the output, try to run again")
+		return
+	}
+	result.Stdout = contestant_output.Stdout
+	result.Stderr = contestant_output.Stderr
+	usage := monitor.Stop()
+	if cpu_limit.Killed(contestant_exit_code, monitor.OOM_Killed(), usage) {
+		result.Verdict = Verdict_Time_Limit_Exceeded
+		result.Message = Message_Cpu_Time_Exceeded
+		return
+	}
+	if result.Verdict = Verdict_From_Exit(contestant_exit_code, monitor.OOM_Killed(), contestant_output); result.Verdict != "" {
+		return
+	}
+	switch interactor_exit_code {
+	case 0:
+		result.Verdict = Verdict_Accepted
+	case 1:
+		result.Verdict = Verdict_Wrong_Answer
+	default:
+		result.Verdict = Verdict_System_Error
+		interactor_output, _ := Get_Container_Output(cli, interactor_id)
+		result.Checker_Error = fmt.Sprintf("interactor exited with code %d: %s", interactor_exit_code, strings.TrimSpace(interactor_output.Stderr))
+	}
+}
+
+// Create_Interactive_Container creates a container whose stdin stays open, so
+// it can be attached to and fed while it runs. The volume of the contestant is
+// mounted read_only, it is shared by every test case of its variant.
+func Create_Interactive_Container(ctx context.Context, cli *client.Client, submission_id string, language Language, command []string, volume_name string, read_only bool, mem_limit_mb int, cpu_limit Cpu_Limit) (string, error) {
+	bind := fmt.Sprintf("%s:/app", volume_name)
+	if read_only {
+		bind += ":ro"
+	}
+	resources := container.Resources{
+		Ulimits: []*units.Ulimit{
+			{
+				Name: "nproc",
+				Soft: 100,
+				Hard: 1024,
+			},
+		},
+		Memory: int64(mem_limit_mb * 1024 * 1024),
+	}
+	cpu_limit.Apply(&resources)
+	cpu_limit.Apply_Ulimit(&resources)
+	resp, err := Create_Container(ctx, cli, submission_id, &container.Config{
+		Image:        language.Image,
+		Env:          language.Env(),
+		Tty:          false,
+		OpenStdin:    true,
+		StdinOnce:    false,
+		AttachStdin:  true,
+		AttachStdout: true,
+		WorkingDir:   "/app",
+		Cmd:          command,
+	}, &container.HostConfig{
+		AutoRemove: false,
+		Binds:      []string{bind},
+		Resources:  resources,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// Checker decides whether the output of a single test case is accepted. An
+// error means the checker itself failed and says nothing about the output.
+// ctx stops a checker that runs a program.
+type Checker interface {
+	Check(ctx context.Context, input string, output string, expected string) (bool, error)
+}
+
+// Strip_Checker accepts an output that equals the expected output once
+// leading and trailing whitespace is removed from both.
+type Strip_Checker struct{}
+
+func (Strip_Checker) Check(ctx context.Context, input string, output string, expected string) (bool, error) {
+	return strings.TrimSpace(output) == strings.TrimSpace(expected), nil
+}
+
+// Token_Checker compares the whitespace separated tokens of both outputs, so
+// line breaks and repeated spaces do not matter.
+type Token_Checker struct{}
+
+func (Token_Checker) Check(ctx context.Context, input string, output string, expected string) (bool, error) {
+	output_tokens := strings.Fields(output)
+	expected_tokens := strings.Fields(expected)
+	if len(output_tokens) != len(expected_tokens) {
+		return false, nil
+	}
+	for index := range output_tokens {
+		if output_tokens[index] != expected_tokens[index] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Float_Checker compares tokens like Token_Checker, but two numeric tokens are
+// equal when they are within the absolute or the relative epsilon.
+type Float_Checker struct {
+	AbsoluteEpsilon float64
+	RelativeEpsilon float64
+}
+
+func (checker Float_Checker) Check(ctx context.Context, input string, output string, expected string) (bool, error) {
+	output_tokens := strings.Fields(output)
+	expected_tokens := strings.Fields(expected)
+	if len(output_tokens) != len(expected_tokens) {
+		return false, nil
+	}
+	for index := range output_tokens {
+		if output_tokens[index] == expected_tokens[index] {
+			continue
+		}
+		actual, err := strconv.ParseFloat(output_tokens[index], 64)
+		if err != nil {
+			return false, nil
+		}
+		wanted, err := strconv.ParseFloat(expected_tokens[index], 64)
+		if err != nil {
+			return false, nil
+		}
+		if math.IsNaN(actual) || math.IsNaN(wanted) {
+			return false, nil
+		}
+		// No epsilon covers an infinity, the difference would be infinite or NaN
+		if math.IsInf(actual, 0) || math.IsInf(wanted, 0) {
+			if actual != wanted {
+				return false, nil
+			}
+			continue
+		}
+		difference := math.Abs(actual - wanted)
+		if difference > checker.AbsoluteEpsilon && difference > checker.RelativeEpsilon*math.Abs(wanted) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Case_Insensitive_Checker behaves like Strip_Checker but ignores letter case.
+type Case_Insensitive_Checker struct{}
+
+func (Case_Insensitive_Checker) Check(ctx context.Context, input string, output string, expected string) (bool, error) {
+	return strings.EqualFold(strings.TrimSpace(output), strings.TrimSpace(expected)), nil
+}
+
+// Line_Set_Checker accepts the expected lines in any order. Trailing spaces
+// and blank lines are ignored, duplicated lines must appear the same number of
+// times.
+type Line_Set_Checker struct{}
+
+func (Line_Set_Checker) Check(ctx context.Context, input string, output string, expected string) (bool, error) {
+	output_lines := Split_Lines(output)
+	expected_lines := Split_Lines(expected)
+	if len(output_lines) != len(expected_lines) {
+		return false, nil
+	}
+	sort.Strings(output_lines)
+	sort.Strings(expected_lines)
+	for index := range output_lines {
+		if output_lines[index] != expected_lines[index] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func Split_Lines(text string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+const checker_time_limit = 10 * time.Second
+const checker_memory_limit_mb = 256
+
+// Program_Checker runs a checker program written in any supported language in
+// the sandbox, prepared once per submission in Volume_Name. It is called as
+// "<execute> /judge/input.txt /judge/output.txt /judge/expected.txt". With
+// VerdictFrom "exit_code" (the default) exit code 0 accepts and 1 rejects the
+// output. With VerdictFrom "stdout" the checker exits with 0 and prints "AC"
+// or "WA" as its first word. Anything else is a checker failure.
+type Program_Checker struct {
+	Cli           *client.Client
+	Submission_ID string
+	Language      string
+	VerdictFrom   string
+	Volume_Name   string
+	// Prepare_Error is set when the program failed to prepare or compile
+	Prepare_Error error
+}
+
+func (checker Program_Checker) Check(ctx context.Context, input string, output string, expected string) (bool, error) {
+	if checker.Prepare_Error != nil {
+		return false, checker.Prepare_Error
+	}
+	files := map[string][]byte{
+		"input.txt":    []byte(input),
+		"output.txt":   []byte(output),
+		"expected.txt": []byte(expected),
+	}
+	args := []string{Judge_File("input.txt"), Judge_File("output.txt"), Judge_File("expected.txt")}
+	slot, err := sandbox_slots.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer sandbox_slots.Release(slot)
+	result, err := Run_Program(ctx, checker.Cli, checker.Submission_ID, checker.Language, files, args, checker_time_limit, checker_memory_limit_mb, checker.Volume_Name)
+	if err != nil {
+		return false, err
+	}
+	if result.Timed_Out {
+		return false, fmt.Errorf("checker exceeded the time limit of %v", checker_time_limit)
+	}
+	if checker.VerdictFrom == "stdout" {
+		if result.Exit_Code != 0 {
+			return false, fmt.Errorf("checker exited with code %d: %s", result.Exit_Code, strings.TrimSpace(result.Stderr))
+		}
+		verdict := strings.Fields(result.Stdout)
+		if len(verdict) == 0 {
+			return false, fmt.Errorf("checker printed no verdict")
+		}
+		switch strings.ToUpper(verdict[0]) {
+		case "AC", "OK":
+			return true, nil
+		case "WA":
+			return false, nil
+		default:
+			return false, fmt.Errorf("checker printed unknown verdict %q", verdict[0])
+		}
+	}
+	switch result.Exit_Code {
+	case 0:
+		return true, nil
+	case 1:
+		return false, nil
+	default:
+		return false, fmt.Errorf("checker exited with code %d: %s", result.Exit_Code, strings.TrimSpace(result.Stderr))
+	}
+}
+
+var default_checker Checker = Strip_Checker{}
+
+// Checker_Programs are the checker programs of a submission by Program_Key.
+// Test cases using the same program share it.
+type Checker_Programs map[string]*Program_Checker
+
+func Program_Key(language string, code string) string {
+	return language + "\x00" + code
+}
+
+// Prepare_Checker_Programs prepares every distinct checker program of the
+// submission in its own volume, all in parallel. A program that fails to
+// prepare fails the test cases using it. Remove_Checker_Programs has to be
+// called once the test cases are judged.
+func Prepare_Checker_Programs(ctx context.Context, cli *client.Client, submission Submission) Checker_Programs {
+	programs := Checker_Programs{}
+	var wg sync.WaitGroup
+	for _, test := range submission.Tests {
+		comparator := test.Comparator
+		key := Program_Key(comparator.Language, comparator.Code)
+		if _, ok := programs[key]; ok || comparator.Type != "program" {
+			continue
+		}
+		checker := &Program_Checker{
+			Cli:           cli,
+			Submission_ID: submission.SubmissionID,
+			Language:      comparator.Language,
+			Volume_Name:   fmt.Sprintf("checker-%s-%d", submission.SubmissionID, len(programs)),
+		}
+		programs[key] = checker
+		wg.Add(1)
+		go func(code string) {
+			defer wg.Done()
+			decoded, err := base64.StdEncoding.DecodeString(code)
+			if err != nil {
+				checker.Prepare_Error = fmt.Errorf("failed to decode checker code: %v", err)
+				return
+			}
+			checker.Prepare_Error = Prepare_Helper_Program(ctx, cli, submission.SubmissionID, checker.Language, decoded, checker.Volume_Name)
+		}(comparator.Code)
+	}
+	wg.Wait()
+	return programs
+}
+
+func Remove_Checker_Programs(cli *client.Client, programs Checker_Programs) {
+	for _, checker := range programs {
+		Remove_Volume(cli, checker.Volume_Name)
+	}
+}
+
+// Prepare_Helper_Program prepares a checker or an interactor in volume_name in
+// a slot of its own. A compile failure is returned as an error.
+func Prepare_Helper_Program(ctx context.Context, cli *client.Client, submission_id string, language string, code []byte, volume_name string) error {
+	slot, err := sandbox_slots.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer sandbox_slots.Release(slot)
+	log.Printf("Prepare %s for submission %v", volume_name, submission_id)
+	compile_result, err := Prepare_Program(ctx, cli, submission_id, language, code, volume_name)
+	if err != nil {
+		return err
+	}
+	if compile_result.Timed_Out || compile_result.Exit_Code != 0 {
+		return fmt.Errorf("failed to compile program: %s", strings.TrimSpace(compile_result.Stderr))
+	}
+	return nil
+}
+
+// Get_Checker returns the checker described by a test case comparator. An
+// empty comparator keeps the default strip and compare behaviour. A program
+// comparator uses its program prepared in programs.
+func Get_Checker(comparator Comparator, programs Checker_Programs) (Checker, error) {
+	switch comparator.Type {
+	case "", "exact":
+		return default_checker, nil
+	case "token":
+		return Token_Checker{}, nil
+	case "float":
+		return Float_Checker{
+			AbsoluteEpsilon: comparator.AbsoluteEpsilon,
+			RelativeEpsilon: comparator.RelativeEpsilon,
+		}, nil
+	case "case_insensitive":
+		return Case_Insensitive_Checker{}, nil
+	case "line_set":
+		return Line_Set_Checker{}, nil
+	case "program":
+		if _, ok := Get_Language(comparator.Language); !ok {
+			return nil, fmt.Errorf("unsupported checker language %q", comparator.Language)
+		}
+		if _, err := base64.StdEncoding.DecodeString(comparator.Code); err != nil {
+			return nil, fmt.Errorf("failed to decode checker code: %v", err)
+		}
+		prepared, ok := programs[Program_Key(comparator.Language, comparator.Code)]
+		if !ok {
+			return nil, fmt.Errorf("checker program was not prepared")
+		}
+		checker := *prepared
+		checker.VerdictFrom = comparator.VerdictFrom
+		return checker, nil
+	default:
+		return nil, fmt.Errorf("unsupported comparator %q", comparator.Type)
+	}
+}
+
+// Judge_Test judges a test case that ran without a verdict by comparing its
+// stdout with the expected output using its comparator, and sets whether it
+// passed. When the checker fails the test case gets a system error and the
+// reason is kept in its CheckerError, unless ctx stopped it, which skips the
+// test case. An error means the test could not be judged at all.
+func Judge_Test(ctx context.Context, programs Checker_Programs, index int, test *Test) error {
+	if test.Result == nil {
+		return fmt.Errorf("no result for test case %d", index)
+	}
+	if test.Result.Verdict == "" {
+		stdout, err := base64.StdEncoding.DecodeString(test.Result.Stdout)
+		if err != nil {
+			return fmt.Errorf("failed to decode stdout %d: %v", index, err)
+		}
+		expected, err := base64.StdEncoding.DecodeString(test.Expected)
+		if err != nil {
+			return fmt.Errorf("failed to decode test case %d: %v", index, err)
+		}
+		input, err := base64.StdEncoding.DecodeString(test.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to decode stdin %d: %v", index, err)
+		}
+		checker, err := Get_Checker(test.Comparator, programs)
+		if err != nil {
+			return fmt.Errorf("test case %d: %v", index, err)
+		}
+		accepted, err := checker.Check(ctx, string(input), string(stdout), string(expected))
+		switch {
+		case err != nil && ctx.Err() != nil:
+			test.Result.Verdict = Verdict_Skipped
+		case err != nil:
+			test.Result.Verdict = Verdict_System_Error
+			test.Result.CheckerError = err.Error()
+		case accepted:
+			test.Result.Verdict = Verdict_Accepted
+		default:
+			test.Result.Verdict = Verdict_Wrong_Answer
+		}
+	}
+	test.Result.Passed = test.Result.Verdict == Verdict_Accepted
+	return nil
+}
+
+// Judge_Submission judges the test cases that are not judged yet, then sets
+// the overall verdict and the score of the submission.
+func Judge_Submission(ctx context.Context, programs Checker_Programs, submission *Submission) error {
+	for index := range submission.Tests {
+		if err := Judge_Test(ctx, programs, index, &submission.Tests[index]); err != nil {
+			return fmt.Errorf("submission %s: %v", submission.SubmissionID, err)
+		}
+	}
+	submission.Verdict = Overall_Verdict(Test_Verdicts(submission.Tests))
+	submission.Score = Score_Submission(*submission)
+	return nil
+}
+
+// Overall_Verdict is the first verdict that is not accepted, or accepted when
+// every test case passed. Skipped test cases follow a failed one of their
+// group, which gives the verdict.
+func Overall_Verdict(verdicts []Verdict) Verdict {
+	for _, verdict := range verdicts {
+		if verdict != Verdict_Accepted && verdict != Verdict_Skipped {
+			return verdict
+		}
+	}
+	return Verdict_Accepted
+}
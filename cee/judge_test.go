@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckers(t *testing.T) {
+	tests := []struct {
+		name     string
+		checker  Checker
+		output   string
+		expected string
+		want     bool
+	}{
+		{"strip equal", Strip_Checker{}, "42\n", "42", true},
+		{"strip surrounding whitespace", Strip_Checker{}, "  1 2\n\n", "1 2", true},
+		{"strip inner whitespace matters", Strip_Checker{}, "1  2", "1 2", false},
+		{"strip different", Strip_Checker{}, "43", "42", false},
+
+		{"token line breaks and spaces", Token_Checker{}, "1\n2   3\n", "1 2 3", true},
+		{"token missing token", Token_Checker{}, "1 2", "1 2 3", false},
+		{"token extra token", Token_Checker{}, "1 2 3 4", "1 2 3", false},
+		{"token different token", Token_Checker{}, "1 2 4", "1 2 3", false},
+		{"token empty", Token_Checker{}, "\n", "", true},
+
+		{"float identical tokens", Float_Checker{}, "abc 1.5", "abc 1.5", true},
+		{"float exact without epsilon", Float_Checker{}, "1.50", "1.5", true},
+		{"float off without epsilon", Float_Checker{}, "1.5000001", "1.5", false},
+		{"float within absolute epsilon", Float_Checker{AbsoluteEpsilon: 1e-6}, "1.0000005", "1", true},
+		{"float at absolute epsilon", Float_Checker{AbsoluteEpsilon: 0.5}, "1.5", "1", true},
+		{"float beyond absolute epsilon", Float_Checker{AbsoluteEpsilon: 1e-6}, "1.000002", "1", false},
+		{"float within relative epsilon", Float_Checker{RelativeEpsilon: 1e-6}, "1000000.5", "1000000", true},
+		{"float beyond relative epsilon", Float_Checker{RelativeEpsilon: 1e-6}, "1000002", "1000000", false},
+		{"float relative epsilon of zero", Float_Checker{RelativeEpsilon: 1e-6}, "0.0000001", "0", false},
+		{"float either epsilon is enough", Float_Checker{AbsoluteEpsilon: 1e-9, RelativeEpsilon: 1e-3}, "1000.5", "1000", true},
+		{"float negative numbers", Float_Checker{AbsoluteEpsilon: 1e-3}, "-2.0005", "-2", true},
+		{"float exponent notation", Float_Checker{AbsoluteEpsilon: 1e-9}, "1e-3", "0.001", true},
+		{"float non numeric output", Float_Checker{AbsoluteEpsilon: 1}, "one", "1", false},
+		{"float non numeric expected", Float_Checker{AbsoluteEpsilon: 1}, "1", "one", false},
+		{"float NaN output", Float_Checker{AbsoluteEpsilon: 1}, "NaN", "1", false},
+		{"float NaN on both sides", Float_Checker{AbsoluteEpsilon: 1}, "nan", "NaN", false},
+		{"float identical NaN tokens", Float_Checker{}, "NaN", "NaN", true},
+		{"float same infinity", Float_Checker{AbsoluteEpsilon: 1}, "+Inf", "Inf", true},
+		{"float infinity against a number", Float_Checker{AbsoluteEpsilon: 1}, "Inf", "1", false},
+		{"float number against infinity", Float_Checker{AbsoluteEpsilon: 1}, "5", "inf", false},
+		{"float number against infinity with relative epsilon", Float_Checker{AbsoluteEpsilon: 1, RelativeEpsilon: 1e-6}, "5", "inf", false},
+		{"float opposite infinities", Float_Checker{AbsoluteEpsilon: 1}, "inf", "-inf", false},
+		{"float opposite infinities with relative epsilon", Float_Checker{AbsoluteEpsilon: 1, RelativeEpsilon: 1e-6}, "inf", "-inf", false},
+		{"float same negative infinity", Float_Checker{RelativeEpsilon: 1e-6}, "-Inf", "-inf", true},
+		{"float token count", Float_Checker{AbsoluteEpsilon: 1}, "1 2", "1", false},
+
+		{"case insensitive", Case_Insensitive_Checker{}, "YES\n", "yes", true},
+		{"case insensitive different", Case_Insensitive_Checker{}, "no", "yes", false},
+
+		{"line set any order", Line_Set_Checker{}, "b\na\nc\n", "a\nb\nc", true},
+		{"line set trailing spaces and blank lines", Line_Set_Checker{}, "b  \r\n\n\na\t\n", "a\nb", true},
+		{"line set duplicates counted", Line_Set_Checker{}, "a\na\nb", "a\nb\nb", false},
+		{"line set missing line", Line_Set_Checker{}, "a", "a\nb", false},
+		{"line set leading spaces matter", Line_Set_Checker{}, " a\nb", "a\nb", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.checker.Check(context.Background(), "", test.output, test.expected)
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Check(%q, %q) = %v, want %v", test.output, test.expected, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetChecker(t *testing.T) {
+	tests := []struct {
+		name       string
+		comparator Comparator
+		want       Checker
+		want_err   bool
+	}{
+		{"empty is exact", Comparator{}, Strip_Checker{}, false},
+		{"exact", Comparator{Type: "exact"}, Strip_Checker{}, false},
+		{"token", Comparator{Type: "token"}, Token_Checker{}, false},
+		{"float", Comparator{Type: "float", AbsoluteEpsilon: 1e-6, RelativeEpsilon: 1e-9}, Float_Checker{AbsoluteEpsilon: 1e-6, RelativeEpsilon: 1e-9}, false},
+		{"case insensitive", Comparator{Type: "case_insensitive"}, Case_Insensitive_Checker{}, false},
+		{"line set", Comparator{Type: "line_set"}, Line_Set_Checker{}, false},
+		{"unknown", Comparator{Type: "regex"}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Get_Checker(test.comparator, nil)
+			if (err != nil) != test.want_err {
+				t.Fatalf("Get_Checker() error = %v, want error %v", err, test.want_err)
+			}
+			if got != test.want {
+				t.Errorf("Get_Checker() = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOverallVerdict(t *testing.T) {
+	tests := []struct {
+		name     string
+		verdicts []Verdict
+		want     Verdict
+	}{
+		{"no test case", nil, Verdict_Accepted},
+		{"every test case accepted", []Verdict{Verdict_Accepted, Verdict_Accepted}, Verdict_Accepted},
+		{"first failure wins", []Verdict{Verdict_Accepted, Verdict_Wrong_Answer, Verdict_Time_Limit_Exceeded}, Verdict_Wrong_Answer},
+		{"skipped after a failure", []Verdict{Verdict_Time_Limit_Exceeded, Verdict_Skipped}, Verdict_Time_Limit_Exceeded},
+		{"skipped alone", []Verdict{Verdict_Accepted, Verdict_Skipped}, Verdict_Accepted},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Overall_Verdict(test.verdicts); got != test.want {
+				t.Errorf("Overall_Verdict(%v) = %v, want %v", test.verdicts, got, test.want)
+			}
+		})
+	}
+}
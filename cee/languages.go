@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/redis/go-redis/v9"
+)
+
+// Language describes how cee runs code of one language. Time limits are in
+// seconds and memory limits in megabytes, they are used for test cases that
+// do not set their own.
+type Language struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	Image       string            `json:"image"`
+	FileName    string            `json:"file_name"`
+	Compile     []string          `json:"compile,omitempty"`
+	Run         []string          `json:"run"`
+	TimeLimit   int               `json:"time_limit"`
+	MemoryLimit int               `json:"memory_limit"`
+	Environment map[string]string `json:"environment,omitempty"`
+	PoolSize    int               `json:"pool_size,omitempty"`
+	// Cpus is the share of CPUs a sandbox gets, 1 by default. CpuTimeLimit
+	// is in seconds and defaults to TimeLimit, which limits the wall time.
+	Cpus         float64 `json:"cpus,omitempty"`
+	CpuTimeLimit int     `json:"cpu_time_limit,omitempty"`
+}
+
+// Language_Info is what a worker advertises about a language in Redis, read by
+// the router to list and validate languages.
+type Language_Info struct {
+	Name         string  `json:"name"`
+	Version      string  `json:"version,omitempty"`
+	TimeLimit    int     `json:"time_limit"`
+	MemoryLimit  int     `json:"memory_limit"`
+	Compiled     bool    `json:"compiled"`
+	Cpus         float64 `json:"cpus"`
+	CpuTimeLimit int     `json:"cpu_time_limit,omitempty"`
+}
+
+// Limits of the languages of SUPPORTED_LANGUAGES, which cannot set them
+const (
+	legacy_time_limit   = 2
+	legacy_memory_limit = 256
+)
+
+// Every worker advertises its languages under language:<name> and refreshes
+// them well before they expire, so languages no worker hosts anymore vanish.
+const (
+	language_key_prefix         = "language:"
+	language_advertise_ttl      = 90 * time.Second
+	language_advertise_interval = 30 * time.Second
+)
+
+type Language_Config struct {
+	Languages []Language `json:"languages"`
+}
+
+func (language Language) Is_Compiled() bool {
+	return len(language.Compile) > 0
+}
+
+// Env returns Environment in the KEY=value form docker expects.
+func (language Language) Env() []string {
+	env := make([]string, 0, len(language.Environment))
+	for key, value := range language.Environment {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Language_Registry holds the languages cee supports. It is replaced as a
+// whole when the configuration is reloaded.
+type Language_Registry struct {
+	mutex     sync.RWMutex
+	languages map[string]Language
+}
+
+var language_registry = &Language_Registry{languages: map[string]Language{}}
+
+func Get_Language(name string) (Language, bool) {
+	language_registry.mutex.RLock()
+	defer language_registry.mutex.RUnlock()
+	language, ok := language_registry.languages[name]
+	return language, ok
+}
+
+// All_Languages returns the supported languages sorted by name.
+func All_Languages() []Language {
+	language_registry.mutex.RLock()
+	defer language_registry.mutex.RUnlock()
+	languages := make([]Language, 0, len(language_registry.languages))
+	for _, language := range language_registry.languages {
+		languages = append(languages, language)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		return languages[i].Name < languages[j].Name
+	})
+	return languages
+}
+
+func Set_Languages(languages []Language) {
+	registry := map[string]Language{}
+	for _, language := range languages {
+		registry[language.Name] = language
+	}
+	language_registry.mutex.Lock()
+	language_registry.languages = registry
+	language_registry.mutex.Unlock()
+}
+
+// Load_Languages reads the languages from the file in LANGUAGES_CONFIG. When it
+// is not set the legacy SUPPORTED_LANGUAGES variable is parsed instead.
+func Load_Languages() ([]Language, error) {
+	path := strings.TrimSpace(os.Getenv("LANGUAGES_CONFIG"))
+	if path == "" {
+		return Parse_Supported_Languages(os.Getenv("SUPPORTED_LANGUAGES"), os.Getenv("SANDBOX_POOL_SIZES"))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read language config: %v", err)
+	}
+	var config Language_Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse language config %s: %v", path, err)
+	}
+	if err := Validate_Languages(config.Languages); err != nil {
+		return nil, fmt.Errorf("invalid language config %s: %v", path, err)
+	}
+	return config.Languages, nil
+}
+
+// Parse_Supported_Languages parses the legacy
+// name@image@extension@type@[compile@]execute entries.
+func Parse_Supported_Languages(value string, pool_sizes_value string) ([]Language, error) {
+	pool_sizes, err := Parse_Pool_Sizes(pool_sizes_value)
+	if err != nil {
+		return nil, err
+	}
+	languages := []Language{}
+	for index, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		details := strings.Split(entry, "@")
+		if len(details) < 4 {
+			return nil, fmt.Errorf("SUPPORTED_LANGUAGES entry %d %q: expected name@image@extension@type@...", index, entry)
+		}
+		language := Language{
+			Name:        details[0],
+			Image:       details[1],
+			FileName:    "code" + details[2],
+			TimeLimit:   legacy_time_limit,
+			MemoryLimit: legacy_memory_limit,
+			PoolSize:    pool_sizes[details[0]],
+		}
+		switch details[3] {
+		case "compiler":
+			if len(details) != 6 {
+				return nil, fmt.Errorf("SUPPORTED_LANGUAGES entry %d %q: a compiler needs name@image@extension@compiler@compile@execute", index, entry)
+			}
+			language.Compile = strings.Fields(details[4])
+			language.Run = strings.Fields(details[5])
+		case "interpreter":
+			if len(details) != 5 {
+				return nil, fmt.Errorf("SUPPORTED_LANGUAGES entry %d %q: an interpreter needs name@image@extension@interpreter@execute", index, entry)
+			}
+			language.Run = strings.Fields(details[4])
+		default:
+			return nil, fmt.Errorf("SUPPORTED_LANGUAGES entry %d %q: unknown type %q", index, entry, details[3])
+		}
+		languages = append(languages, language)
+	}
+	if err := Validate_Languages(languages); err != nil {
+		return nil, fmt.Errorf("invalid SUPPORTED_LANGUAGES: %v", err)
+	}
+	return languages, nil
+}
+
+func Validate_Languages(languages []Language) error {
+	if len(languages) == 0 {
+		return fmt.Errorf("no language configured")
+	}
+	seen := map[string]bool{}
+	for index, language := range languages {
+		field := fmt.Sprintf("languages[%d]", index)
+		if language.Name == "" {
+			return fmt.Errorf("%s: name is required", field)
+		}
+		field = fmt.Sprintf("languages[%d] (%s)", index, language.Name)
+		if seen[language.Name] {
+			return fmt.Errorf("%s: duplicated name", field)
+		}
+		seen[language.Name] = true
+		if language.Image == "" {
+			return fmt.Errorf("%s: image is required", field)
+		}
+		if language.FileName == "" || strings.Contains(language.FileName, "/") {
+			return fmt.Errorf("%s: file_name must be a plain file name", field)
+		}
+		if len(language.Run) == 0 {
+			return fmt.Errorf("%s: run is required", field)
+		}
+		if language.TimeLimit <= 0 {
+			return fmt.Errorf("%s: time_limit must be positive", field)
+		}
+		if language.MemoryLimit < 6 {
+			return fmt.Errorf("%s: memory_limit must be at least 6 MB", field)
+		}
+		if language.PoolSize < 0 {
+			return fmt.Errorf("%s: pool_size must not be negative", field)
+		}
+		if language.Cpus < 0 {
+			return fmt.Errorf("%s: cpus must not be negative", field)
+		}
+		if language.CpuTimeLimit < 0 {
+			return fmt.Errorf("%s: cpu_time_limit must not be negative", field)
+		}
+	}
+	return nil
+}
+
+// Pull_Language_Images pulls the image of every language in parallel and
+// returns the first error.
+func Pull_Language_Images(cli *client.Client, languages []Language) error {
+	var wg sync.WaitGroup
+	errorChan := make(chan error, len(languages))
+	for _, language := range languages {
+		wg.Add(1)
+		go func(language Language) {
+			defer wg.Done()
+			out, err := cli.ImagePull(context.Background(), language.Image, types.ImagePullOptions{})
+			if err != nil {
+				errorChan <- fmt.Errorf("failed to pull image %v: %v", language.Image, err.Error())
+				return
+			}
+			defer out.Close()
+			io.Copy(io.Discard, out)
+			log.Println("Finished pulling image:", language.Image)
+		}(language)
+	}
+	wg.Wait()
+	close(errorChan)
+	return <-errorChan
+}
+
+// Initialize_Language_Executor loads the languages, pulls their images and
+// makes them available to the handlers.
+func Initialize_Language_Executor(cli *client.Client) ([]Language, error) {
+	languages, err := Load_Languages()
+	if err != nil {
+		return nil, err
+	}
+	for _, language := range languages {
+		log.Printf("Language details: %+v\n", language)
+	}
+	if err := Pull_Language_Images(cli, languages); err != nil {
+		return nil, err
+	}
+	Set_Languages(languages)
+	return languages, nil
+}
+
+// Watch_Language_Config reloads the languages on SIGHUP, and every interval
+// when the config file changed, which is how a mounted ConfigMap is updated.
+// A config that fails to load is logged and the current languages are kept.
+func Watch_Language_Config(cli *client.Client, interval time.Duration, on_reload func([]Language)) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	path := strings.TrimSpace(os.Getenv("LANGUAGES_CONFIG"))
+	last_modified := Config_Modified_Time(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hangup:
+		case <-ticker.C:
+			modified := Config_Modified_Time(path)
+			if modified.Equal(last_modified) {
+				continue
+			}
+			last_modified = modified
+		}
+		log.Printf("Reloading language config")
+		languages, err := Initialize_Language_Executor(cli)
+		if err != nil {
+			log.Printf("Failed to reload language config, keeping the current one: %v", err)
+			continue
+		}
+		on_reload(languages)
+	}
+}
+
+func Config_Modified_Time(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Advertise_Languages writes the languages to Redis for the router.
+func Advertise_Languages(redis_client *redis.Client, languages []Language) error {
+	ctx := context.Background()
+	pipe := redis_client.Pipeline()
+	for _, language := range languages {
+		info, err := json.Marshal(Language_Info{
+			Name:         language.Name,
+			Version:      language.Version,
+			TimeLimit:    language.TimeLimit,
+			MemoryLimit:  language.MemoryLimit,
+			Compiled:     language.Is_Compiled(),
+			Cpus:         Language_Cpus(language),
+			CpuTimeLimit: language.CpuTimeLimit,
+		})
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, language_key_prefix+language.Name, info, language_advertise_ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Keep_Languages_Advertised advertises the current languages every interval so
+// they do not expire while the worker runs.
+func Keep_Languages_Advertised(redis_client *redis.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := Advertise_Languages(redis_client, All_Languages()); err != nil {
+			log.Printf("Failed to advertise languages: %v", err)
+		}
+		<-ticker.C
+	}
+}
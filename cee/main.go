@@ -7,26 +7,24 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"runtime/debug"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-units"
 	"github.com/pbnjay/memory"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/redis/go-redis/v9"
 )
 
-var supported_languages []string = strings.Split(os.Getenv("SUPPORTED_LANGUAGES"), ",")
 var submission_queue string = strings.TrimSpace(os.Getenv("SUBMISSION_QUEUE"))
 var rabbit_password string = strings.TrimSpace(os.Getenv("RABBITMQ_PASSWORD"))
 var rabbitmq_username string = strings.TrimSpace(os.Getenv("RABBMITMQ_USERNAME"))
@@ -38,8 +36,10 @@ var redis_sentinel_address string = strings.TrimSpace(os.Getenv("REDIS_SENTINELS
 // TODO: remember to change this to the correct queue name
 var queue_name string = strings.TrimSpace(os.Getenv("CEE_INTERPRETER_QUEUE_NAME"))
 
-// language_details[language_name]["image"/"cmd"]
-var languages_details map[string]map[string]string = map[string]map[string]string{}
+const (
+	mq_first_backoff = time.Second
+	mq_max_backoff   = 30 * time.Second
+)
 
 func Initiate_Redis_Client() (*redis.Client, error) {
 	context := context.Background()
@@ -67,93 +67,44 @@ func Initiate_Redis_Client() (*redis.Client, error) {
 
 func main() {
 	cli, err := Initialize_Docker_Client()
-	log.Printf("%v", supported_languages)
 	if err != nil {
 		log.Fatal("Failed to initialize docker client\n" + err.Error())
 	}
-	_, err = Initialize_Language_Executor(cli)
+	languages, err := Initialize_Language_Executor(cli)
 	if err != nil {
 		log.Fatal("Failed to initialize language executor\n" + err.Error())
 		return // Exiting function if there's an error
 	}
+	// The queues are resolved once, a reload that adds a language on another
+	// queue needs a restart to consume it
+	queues, err := Worker_Queues(languages)
+	if err != nil {
+		log.Fatal("Failed to resolve the queues to consume\n" + err.Error())
+	}
+	sandbox_pool = New_Sandbox_Pool(cli, languages)
+	sandbox_slots = New_Sandbox_Slots(Slot_Count())
+	log.Printf("Running up to %d sandboxes, %d test cases per submission, %d submissions prefetched", sandbox_slots.Size(), Submission_Parallelism(sandbox_slots.Size()), Prefetch_Count(sandbox_slots.Size()))
 	redis_client, err := Initiate_Redis_Client()
 	if err != nil {
 		log.Fatal("Failed to initialize redis client\n" + err.Error())
 	}
-	consume(cli, redis_client)
-}
-
-func Initialize_Language_Executor(cli *client.Client) (bool, error) {
-	var wg sync.WaitGroup
-	errorChan := make(chan error, len(supported_languages))
-	finishChan := make(chan bool, len(supported_languages))
-	for _, language := range supported_languages {
-		wg.Add(1)
-		go func(language string) {
-			defer wg.Done()
-			language_details := strings.Split(language, "@")
-			log.Printf("Lanugage details: %s\n", language_details)
-			language_name := language_details[0]
-			language_image := language_details[1]
-			language_extension := language_details[2]
-			lanugage_type := language_details[3]
-			if lanugage_type == "compiler" {
-				languages_details[language_name] = map[string]string{
-					"image":     language_image,
-					"compile":   language_details[4],
-					"execute":   language_details[5],
-					"extension": language_extension,
-					"type":      "compiler",
-				}
-			} else {
-				languages_details[language_name] = map[string]string{
-					"image":     language_image,
-					"execute":   language_details[4],
-					"extension": language_extension,
-					"type":      "interpreter",
-				}
-			}
-			out, err := cli.ImagePull(context.Background(), language_image, types.ImagePullOptions{})
-			if err != nil {
-				errorChan <- fmt.Errorf("failed to pull image %v: %v", language_image, err.Error())
-				return
-			}
-			defer out.Close()
-			io.Copy(io.Discard, out)
-
-			log.Println("Finished pulling image:", language_details)
-			finishChan <- true // Indicate that image has been pulled successfully
-		}(language)
-	}
-	go func() {
-		wg.Wait()
-		close(errorChan)
-		close(finishChan)
-	}()
-	var errorEncountered error
-	finishedTasks := 0
-	for {
-		select {
-		case err, ok := <-errorChan:
-			if ok && err != nil && errorEncountered == nil { // Only recording the first error
-				errorEncountered = err
-			}
-		case _, ok := <-finishChan:
-			if ok {
-				finishedTasks++
-			}
-		}
-
-		if finishedTasks == len(supported_languages) || errorEncountered != nil {
-			break
+	go Keep_Languages_Advertised(redis_client, language_advertise_interval)
+	go Keep_Worker_Alive(redis_client, worker_heartbeat_interval)
+	go Keep_Reaping(cli, redis_client, Sandbox_TTL(), reap_interval)
+	go Watch_Language_Config(cli, 30*time.Second, func(languages []Language) {
+		sandbox_pool.Reset(languages)
+		if err := Advertise_Languages(redis_client, languages); err != nil {
+			log.Printf("Failed to advertise languages: %v", err)
 		}
-	}
-
-	if errorEncountered != nil {
-		return false, errorEncountered
-	}
-
-	return true, nil
+	})
+	shutdown_ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	consume(shutdown_ctx, cli, redis_client, queues)
+	sandbox_pool.Close()
+	sandboxes.Remove_All(cli)
+	// Nothing of this worker is left for the reapers of the others
+	redis_client.Del(context.Background(), Worker_Key(worker_id))
+	log.Printf("cee stopped")
 }
 
 func Initialize_Docker_Client() (*client.Client, error) {
@@ -178,146 +129,337 @@ func injectUsernamePasswordToRabbitMQURL(rabbitMQURL string, rabbitMQUsername st
 	return rabbitMQURL
 }
 
-func consume(cli *client.Client, redis_client *redis.Client) {
+// consume keeps consuming the queues. When the connection or the channel is
+// lost, e.g. the broker restarted, it reconnects with backoff, declares the
+// queues and sets the prefetch count again. Messages that were not acked are
+// redelivered by RabbitMQ. It returns once shutdown_ctx is done and the
+// submissions being judged are drained.
+func consume(shutdown_ctx context.Context, cli *client.Client, redis_client *redis.Client, queues []string) {
+	fmt.Println("Running...")
+	backoff := mq_first_backoff
+	for {
+		consumed, err := Consume_Until_Closed(shutdown_ctx, cli, redis_client, queues)
+		if shutdown_ctx.Err() != nil {
+			// Also reached when the connection was lost as the shutdown began
+			Drain_Handlers(Drain_Timeout())
+			return
+		}
+		if consumed {
+			backoff = mq_first_backoff
+		}
+		log.Printf("Reconnecting to RabbitMQ in %v: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-shutdown_ctx.Done():
+			Drain_Handlers(Drain_Timeout())
+			return
+		}
+		backoff *= 2
+		if backoff > mq_max_backoff {
+			backoff = mq_max_backoff
+		}
+	}
+}
+
+// Consume_Until_Closed consumes the queues until the connection or the
+// channel closes or shutdown_ctx is done. consumed tells whether it got as far
+// as consuming.
+func Consume_Until_Closed(shutdown_ctx context.Context, cli *client.Client, redis_client *redis.Client, queues []string) (consumed bool, err error) {
 	conn, err := Initiate_MQ_Client()
 	if err != nil {
-		log.Fatal("Failed to connect to RabbitMQ", err)
+		return false, fmt.Errorf("failed to connect to RabbitMQ: %v", err)
 	}
 	defer conn.Close()
-	notify := conn.NotifyClose(make(chan *amqp.Error))
+	// Buffered, amqp blocks until the close is delivered
+	conn_closed := conn.NotifyClose(make(chan *amqp.Error, 1))
 	ch, err := Initiate_MQ_Channel(conn)
 	if err != nil {
-		log.Fatal("Failed to open a channel", err)
+		return false, fmt.Errorf("failed to open a channel: %v", err)
 	}
-	defer ch.Close()
-	err = Declare_MQ_Queue(ch, queue_name)
-	if err != nil {
-		log.Fatal("Failed to declare a queue", err)
+	ch_closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	if err := SetPrefetchCount(ch, Prefetch_Count(sandbox_slots.Size())); err != nil {
+		return false, fmt.Errorf("failed to set prefetch count: %v", err)
 	}
-	if err := SetPrefetchCount(ch, 1); err != nil {
-		log.Fatal("Failed to set prefetch count", err)
+	for _, queue := range queues {
+		if err := Declare_MQ_Queue(ch, queue); err != nil {
+			return false, fmt.Errorf("failed to declare queue %s: %v", queue, err)
+		}
+		if err := Declare_Retry_Queues(ch, queue); err != nil {
+			return false, fmt.Errorf("failed to declare the retry queues of %s: %v", queue, err)
+		}
+		msgs, err := ch.Consume(
+			queue,
+			"cee-"+queue,
+			false,
+			false,
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			return false, fmt.Errorf("failed to consume queue %s: %v", queue, err)
+		}
+		log.Printf("Consuming queue %s", queue)
+		go OnMessageReceived(msgs, ch, cli, redis_client)
 	}
-	msgs, err := ch.Consume(
-		queue_name,
-		"cee",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Fatal("Failed to register a consumer", err)
+	select {
+	case err = <-conn_closed:
+	case err = <-ch_closed:
+	case <-shutdown_ctx.Done():
+		log.Printf("Shutting down, no more submissions are consumed")
+		for _, queue := range queues {
+			if err := ch.Cancel("cee-"+queue, false); err != nil {
+				log.Printf("Failed to stop consuming queue %s: %v", queue, err)
+			}
+		}
+		// The connection stays open for the drained submissions to ack
+		Drain_Handlers(Drain_Timeout())
+		return true, nil
 	}
-	forever := make(chan bool)
-	go OnMessageReceived(msgs, cli, notify, redis_client)
-	fmt.Println("Running...")
-	<-forever
+	return true, fmt.Errorf("AMQP connection closed: %v", err)
 }
 
-func OnMessageReceived(msgs <-chan amqp.Delivery, cli *client.Client, notifyClose <-chan *amqp.Error, redis_client *redis.Client) {
-	go func() {
-		for err := range notifyClose {
-			log.Printf("AMQP connection closed: %v", err)
-		}
-	}()
+func OnMessageReceived(msgs <-chan amqp.Delivery, ch *amqp.Channel, cli *client.Client, redis_client *redis.Client) {
 	for d := range msgs {
-		go Message_Handler(&d, cli, redis_client)
+		d := d
+		if !in_flight.Start() {
+			// Delivered before the consumer was cancelled
+			Requeue_Message(ch, &d, "")
+			continue
+		}
+		go func() {
+			defer in_flight.Done()
+			Message_Handler(&d, ch, cli, redis_client)
+		}()
 	}
 }
 
-func Message_Handler(d *amqp.Delivery, cli *client.Client, redis_client *redis.Client) {
+func Message_Handler(d *amqp.Delivery, ch *amqp.Channel, cli *client.Client, redis_client *redis.Client) {
 	body := d.Body
 	submission_id, err := Get_Submission_Token_From_MQ(body)
 	startTime := time.Now()
 	log.Printf("Handle a message: %s", submission_id)
-	if err != nil {
-		log.Printf("Error getting submission id from MQ\n" + err.Error())
+	if err != nil || submission_id == "" {
+		log.Printf("Error getting submission id from MQ: %v", err)
+		Reject_Message(ch, redis_client, d, "", Fatal_Error("message has no submission id"))
+		return
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("Panic while handling submission %s: %v\n%s", submission_id, recovered, debug.Stack())
+			Reject_Message(ch, redis_client, d, submission_id, Fatal_Error("cee failed while judging: %v", recovered))
+		}
+	}()
+	if d.Redelivered {
+		// The worker judging it may have crashed on it. Going through the
+		// retry queue counts the attempt, so such a message is dead-lettered
+		// instead of crashing every worker.
+		Reject_Message(ch, redis_client, d, submission_id, Retryable_Error("message was redelivered"))
+		return
 	}
 	result, err := Get_Submission_From_Redis(redis_client, submission_id)
+	if err == redis.Nil {
+		Reject_Message(ch, redis_client, d, submission_id, Fatal_Error("submission not found in redis, it may have expired"))
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to get submission from redis\n" + err.Error())
+		Reject_Message(ch, redis_client, d, submission_id, Retryable_Error("failed to get submission from redis: %v", err))
+		return
 	}
 	submission, err := Parse_Submission_From_Redis(result)
 	if err != nil {
 		log.Printf("Failed to parse submission from redis\n" + err.Error())
+		Reject_Message(ch, redis_client, d, submission_id, Fatal_Error("failed to parse submission: %v", err))
+		return
 	}
-	execution_channel := make(chan Execution_Result, len(submission.Stdin))
-	threading_ctx, cancel := context.WithCancel(context.Background())
-	log.Printf("Before running code for submission %s", submission_id)
-	// Set Max number of goroutines
-	guard := make(chan struct{}, 2)
-	for index, code_input := range submission.Stdin {
-		guard <- struct{}{} // would block if guard channel is already filled
-		// base64 decode code_input\
-		code_input_decoded, err := base64.StdEncoding.DecodeString(code_input)
-		if err != nil {
-			log.Printf("Failed to decode input\n" + err.Error())
+	legacy := Is_Legacy(submission)
+	var build_err error
+	if legacy {
+		build_err = Tests_From_Legacy(&submission)
+	}
+	execution_channel := make(chan Execution_Result, len(submission.Tests))
+	threading_ctx, cancel := context.WithCancel(abort_ctx)
+	defer cancel()
+	variants, variant_of, err := Build_Code_Variants(submission)
+	if build_err == nil {
+		build_err = err
+	}
+	if build_err != nil {
+		log.Printf("Failed to build code of submission %s\n"+build_err.Error(), submission_id)
+	}
+	// Interpreted code is copied to every container, only compiled code and
+	// interactive runs need a prepared volume
+	language, _ := Get_Language(submission.Language)
+	if language.Is_Compiled() || submission.Interactor != nil {
+		if language.Is_Compiled() {
+			Publish_Event(redis_client, Submission_Event{Type: Event_Compiling, SubmissionID: submission_id})
 		}
-		code, err := base64.StdEncoding.DecodeString(submission.Code)
-		if err != nil {
-			log.Printf("Failed to decode code\n" + err.Error())
+		Prepare_Code_Variants(threading_ctx, cli, submission.Language, variants, submission_id)
+		defer Remove_Code_Variants(cli, variants)
+	}
+	// Checkers and the interactor are prepared once for every test case
+	checkers := Checker_Programs{}
+	var interactor *Interactor_Program
+	if build_err == nil {
+		checkers = Prepare_Checker_Programs(threading_ctx, cli, submission)
+		defer Remove_Checker_Programs(cli, checkers)
+		if submission.Interactor != nil {
+			interactor = Prepare_Interactor(threading_ctx, cli, submission_id, *submission.Interactor)
+			defer Remove_Volume(cli, interactor.Volume_Name)
 		}
-		for _, replaces := range submission.Replace[index] {
-			from, err := base64.StdEncoding.DecodeString(replaces.From)
-			if err != nil {
-				log.Printf("Failed to decode replace from\n" + err.Error())
+	}
+	for _, variant := range variants {
+		if variant.Verdict == Verdict_Compile_Error {
+			submission.CompileOutput = base64.StdEncoding.EncodeToString([]byte(variant.Output))
+			break
+		}
+	}
+	log.Printf("Before running code for submission %s", submission_id)
+	// Groups that stop early skip their test cases once one failed, which the
+	// results below tell while the test cases are dispatched
+	failed_groups := &Failed_Groups{}
+	stops_early := map[string]bool{}
+	for _, test := range submission.Tests {
+		stops_early[test.Group] = Stops_Early(submission, test.Group)
+	}
+	go func() {
+		// The test cases of the submission running at once, each of them also
+		// waits for a slot shared with the other submissions
+		guard := make(chan struct{}, Submission_Parallelism(sandbox_slots.Size()))
+		for index, test := range submission.Tests {
+			if build_err != nil {
+				Put_Execution_Result_To_Channel(execution_channel, System_Error_Result(index, "Invalid submission: "+build_err.Error()))
+				continue
 			}
-			to, err := base64.StdEncoding.DecodeString(replaces.To)
+			variant := variants[variant_of[index]]
+			if variant.Verdict != "" {
+				Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
+					Submission_Index: index,
+					Verdict:          variant.Verdict,
+					Message:          variant.Message,
+				})
+				continue
+			}
+			time_limit := test.Limits.TimeLimit
+			if time_limit <= 0 {
+				time_limit = language.TimeLimit
+			}
+			mem_limit := test.Limits.MemoryLimit
+			if mem_limit <= 0 {
+				mem_limit = language.MemoryLimit
+			}
+			if time_limit <= 0 || mem_limit <= 0 {
+				// Would time out at once, or run without a memory limit
+				Put_Execution_Result_To_Channel(execution_channel, System_Error_Result(index, "No time or memory limit for the test case"))
+				continue
+			}
+			guard <- struct{}{} // would block if guard channel is already filled
+			skipped := failed_groups.Has(test.Group) || threading_ctx.Err() != nil
+			// An interactive run takes a second slot for its interactor
+			slot_count := 1
+			if submission.Interactor != nil {
+				slot_count = 2
+			}
+			var slots []int
+			if !skipped {
+				var err error
+				slots, err = sandbox_slots.Acquire_Many(threading_ctx, slot_count)
+				skipped = err != nil
+			}
+			if skipped {
+				<-guard
+				Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
+					Submission_Index: index,
+					Verdict:          Verdict_Skipped,
+				})
+				continue
+			}
+			// base64 decode code_input\
+			code_input_decoded, err := base64.StdEncoding.DecodeString(test.Stdin)
 			if err != nil {
-				log.Printf("Failed to decode replace to\n" + err.Error())
+				log.Printf("Failed to decode input\n" + err.Error())
+			}
+			cpu_limit := Test_Cpu_Limit(language, test.Limits, time_limit, slots[0])
+			if submission.Interactor != nil {
+				expected, err := base64.StdEncoding.DecodeString(test.Expected)
+				if err != nil {
+					log.Printf("Failed to decode test case\n" + err.Error())
+				}
+				go func(index int) {
+					Publish_Event(redis_client, Test_Event(Event_Running, submission_id, index, ""))
+					Run_Interactive(threading_ctx, cli, variant.Volume_Name, string(code_input_decoded), string(expected), submission.Language, interactor, time_limit, mem_limit, cpu_limit, submission_id, index, execution_channel)
+					sandbox_slots.Release_Many(slots)
+					<-guard
+				}(index)
+				continue
 			}
-			code = bytes.Replace(code, from, to, -1)
+			go func(index int) {
+				Publish_Event(redis_client, Test_Event(Event_Running, submission_id, index, ""))
+				RunCode(threading_ctx, cli, variant, string(code_input_decoded), submission.Language, time_limit, mem_limit, cpu_limit, index, execution_channel, submission_id)
+				sandbox_slots.Release_Many(slots)
+				<-guard // read from the guard channel, allows another iteration to proceed
+			}(index)
+		}
+	}()
+	// Every test case is judged as soon as it ran, so its group can stop early
+	for i := 0; i < len(submission.Tests); i++ {
+		result := <-execution_channel
+		test := &submission.Tests[result.Submission_Index]
+		test.Result = &Test_Result{
+			Verdict:      result.Verdict,
+			Message:      result.Message,
+			Stdout:       base64.StdEncoding.EncodeToString([]byte(result.Stdout)),
+			Stderr:       base64.StdEncoding.EncodeToString([]byte(result.Stderr)),
+			Usage:        result.Usage,
+			CheckerError: result.Checker_Error,
+		}
+		if err := Judge_Test(threading_ctx, checkers, result.Submission_Index, test); err != nil {
+			log.Printf("Failed to judge submission %s\n"+err.Error(), submission_id)
+			test.Result.Verdict = Verdict_System_Error
+			test.Result.Message = "Failed to judge the output"
 		}
-		time_limit := submission.TimeLimit[index]
-		mem_limit := submission.MemoryLimit[index]
-		go func(index int) {
-			RunCode(threading_ctx, cli, code, string(code_input_decoded), submission.Language, time_limit, mem_limit, index, execution_channel, submission_id)
-			<-guard // read from the guard channel, allows another iteration to proceed
-		}(index)
-	}
-	submission_results := []Execution_Result{}
-	for i := 0; i < len(submission.Stdin); i++ {
-		submission_result := <-execution_channel
-		submission_results = append(submission_results, submission_result)
+		if test.Result.Verdict != Verdict_Accepted && test.Result.Verdict != Verdict_Skipped {
+			if stops_early[test.Group] {
+				failed_groups.Add(test.Group)
+			}
+			if submission.StopOnFirstFailure {
+				// Kills the running test cases and skips the queued ones
+				cancel()
+			}
+		}
+		Publish_Event(redis_client, Test_Event(Event_Test_Finished, submission_id, result.Submission_Index, test.Result.Verdict))
 	}
 	cancel()
+	if abort_ctx.Err() != nil {
+		// cee is shutting down, another worker judges it from the start
+		log.Printf("Requeueing submission %s, cee is shutting down", submission_id)
+		Requeue_Message(ch, d, submission_id)
+		return
+	}
 	log.Printf("Done running code for submission %s", submission.SubmissionID)
-	reordered_submission_results := make([]Execution_Result, len(submission_results))
-	for _, result := range submission_results {
-		reordered_submission_results[result.Submission_Index] = result
+	if err = Judge_Submission(abort_ctx, checkers, &submission); err != nil {
+		log.Printf("Failed to judge submission\n" + err.Error())
+		submission.Verdict = Verdict_System_Error
 	}
-	for _, result := range reordered_submission_results {
-		base64_stdout := base64.StdEncoding.EncodeToString([]byte(result.Stdout))
-		base64_stderr := base64.StdEncoding.EncodeToString([]byte(result.Stderr))
-		submission.Stdout = append(submission.Stdout, base64_stdout)
-		submission.Stderr = append(submission.Stderr, base64_stderr)
+	if legacy {
+		Legacy_From_Tests(&submission)
 	}
+	submission.Status = "done execution"
 	if err = Save_Submission_To_Redis(submission, redis_client); err != nil {
-		log.Printf("Failed to save submission to redis\n" + err.Error())
-	}
-	log.Printf("Before Judge Submission for %s", submission.SubmissionID)
-	statusCode, err := Judge_Submission(submission.SubmissionID)
-	if err != nil {
-		log.Printf("Failed to judge submission\n" + err.Error())
-	}
-	if statusCode == 200 {
-		submission_after_judge, err := Get_Submission_From_Redis(redis_client, submission.SubmissionID)
-		if err != nil {
-			log.Printf("Failed to get submission after judge from redis\n" + err.Error())
-		}
-		submission_after_judge_parsed, err := Parse_Submission_From_Redis(submission_after_judge)
-		if err != nil {
-			log.Printf("Failed to parse submission after judge from redis\n" + err.Error())
-		}
-		submission_after_judge_parsed.Status = "done execution"
-		if err = Save_Submission_To_Redis(submission_after_judge_parsed, redis_client); err != nil {
-			log.Printf("Failed to save judged submission to redis\n" + err.Error())
-		}
-		log.Printf("After Judge Submission Response with updated status for %s", submission.SubmissionID)
+		log.Printf("Failed to save judged submission to redis\n" + err.Error())
+		// The result is lost, the submission is judged again
+		Reject_Message(ch, redis_client, d, submission_id, Retryable_Error("failed to save judged submission: %v", err))
+		return
 	}
+	Publish_Event(redis_client, Submission_Event{Type: Event_Judged, SubmissionID: submission_id, Verdict: submission.Verdict})
+	go Notify_Callback(redis_client, submission)
+	log.Printf("After Judge Submission with updated status for %s", submission.SubmissionID)
 	elapsedTime := time.Since(startTime)
-	d.Ack(false)
+	if err := d.Ack(false); err != nil {
+		// The channel was lost while judging, RabbitMQ delivers it again
+		log.Printf("Failed to ack submission %s: %v", submission_id, err)
+	}
 	log.Printf("Done processing submission: %s with %v", submission_id, elapsedTime.Seconds())
 }
 
@@ -371,7 +513,7 @@ func Initiate_Docker_Client() (*client.Client, error) {
 
 func Initiate_MQ_Client() (*amqp.Connection, error) {
 	var rabbitmq_url string = injectUsernamePasswordToRabbitMQURL(submission_queue, rabbitmq_username, rabbit_password)
-	log.Printf("RabbitMQ URL: %s", rabbitmq_url)
+	log.Printf("RabbitMQ URL: %s", Redact_URL(rabbitmq_url))
 	conn, err := amqp.Dial(rabbitmq_url)
 	if err != nil {
 		return nil, err
@@ -379,6 +521,15 @@ func Initiate_MQ_Client() (*amqp.Connection, error) {
 	return conn, nil
 }
 
+// Redact_URL hides the password of a URL so it can be logged.
+func Redact_URL(raw_url string) string {
+	parsed, err := url.Parse(raw_url)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	return parsed.Redacted()
+}
+
 func Declare_MQ_Queue(ch *amqp.Channel, queue_name string) error {
 	_, err := ch.QueueDeclare(
 		queue_name,
@@ -420,39 +571,25 @@ func Get_Data_From_Redis(redis_client *redis.Client, key string) (string, error)
 	return val, nil
 }
 
-func executeCode(cli *client.Client, code []byte, input string, language string, time_limit int, mem_limit_mb int, submission_id string, submission_index int, execution_channel chan Execution_Result, threading_ctx context.Context) {
+func executeCode(cli *client.Client, code []byte, input string, language string, time_limit int, mem_limit_mb int, cpu_limit Cpu_Limit, submission_id string, submission_index int, execution_channel chan Execution_Result, threading_ctx context.Context) {
 	ctx := context.Background()
 	// Create a container
 	log.Printf("Create a container for submission %v index %v", submission_id, submission_index)
-	resp, err := cli.ContainerCreate(ctx, &container.Config{
-		Image:      languages_details[language]["image"],
-		Tty:        false,
-		OpenStdin:  true,
-		WorkingDir: "/app",
-		Cmd:        strings.Split(languages_details[language]["execute"], " "),
-	}, &container.HostConfig{
-		AutoRemove: false,
-		Resources: container.Resources{
-			Ulimits: []*units.Ulimit{
-				{
-					Name: "nproc",
-					Soft: 1024,
-					Hard: 2048,
-				},
-			},
-			Memory: int64(mem_limit_mb * 1024 * 1024),
-		},
-	}, nil, nil, "")
+	container_id, err := sandbox_pool.Acquire(ctx, submission_id, language, mem_limit_mb, cpu_limit)
 	if err != nil {
 		log.Println("Error in creating container\n " + err.Error())
+		Put_Execution_Result_To_Channel(execution_channel, System_Error_Result(submission_index, "Sandbox error, try to run again"))
+		return
 	}
+	defer sandbox_pool.Release(container_id)
 	log.Printf("Add file to container for submission %v index %v", submission_id, submission_index)
 	// Copy code to container
-	content, err := Make_Archieve(fmt.Sprintf("code%s", languages_details[language]["extension"]), code)
+	details, _ := Get_Language(language)
+	content, err := Make_Archieve(details.FileName, code)
 	if err != nil {
 		log.Println("Error in creating container\n " + err.Error())
 	}
-	err = cli.CopyToContainer(ctx, resp.ID, "/app", content, types.CopyToContainerOptions{
+	err = cli.CopyToContainer(ctx, container_id, "/app", content, types.CopyToContainerOptions{
 		AllowOverwriteDirWithFile: true,
 	})
 	if err != nil {
@@ -460,13 +597,14 @@ func executeCode(cli *client.Client, code []byte, input string, language string,
 	}
 	log.Printf("Start container for submission %v index %v", submission_id, submission_index)
 	// Start compiling container
-	err = cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	err = cli.ContainerStart(ctx, container_id, types.ContainerStartOptions{})
 	if err != nil {
 		log.Println("Error in creating container\n " + err.Error())
 	}
+	monitor := Start_Usage_Monitor(cli, container_id)
 	log.Printf("Write input to container for submission %v index %v", submission_id, submission_index)
 	// Write input to container
-	hijackedResponse, err := cli.ContainerAttach(ctx, resp.ID, types.ContainerAttachOptions{
+	hijackedResponse, err := cli.ContainerAttach(ctx, container_id, types.ContainerAttachOptions{
 		Stream: true,
 		Stdin:  true,
 		Stdout: false,
@@ -474,7 +612,10 @@ func executeCode(cli *client.Client, code []byte, input string, language string,
 	})
 	if err != nil {
 		log.Println("Error in creating container\n " + err.Error())
+		Put_Execution_Result_To_Channel(execution_channel, System_Error_Result(submission_index, "Sandbox error, try to run again"))
+		return
 	}
+	defer hijackedResponse.Close()
 	_, err = hijackedResponse.Conn.Write([]byte(input + "\n"))
 	if err != nil {
 		log.Println("Error in writing input to container\n " + err.Error())
@@ -483,235 +624,92 @@ func executeCode(cli *client.Client, code []byte, input string, language string,
 	time_limit_ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time_limit)*time.Second)
 	defer cancel()
 	// Wait for container to finish
-	statusCh, errCh := cli.ContainerWait(time_limit_ctx, resp.ID, container.WaitConditionNotRunning)
+	statusCh, errCh := cli.ContainerWait(time_limit_ctx, container_id, container.WaitConditionNotRunning)
 	select {
 	case err := <-errCh:
-		if err != nil {
-			Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-				Submission_Index: submission_index,
-				Stdout:           "",
-				Stderr:           "Sandbox error, try to run again",
-			})
+		usage := monitor.Stop()
+		if time_limit_ctx.Err() != nil {
+			cli.ContainerStop(ctx, container_id, container.StopOptions{})
+			Put_Execution_Result_To_Channel(execution_channel, Time_Limit_Result(submission_index, Message_Wall_Time_Exceeded, usage))
+			return
 		}
-		cli.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{
-			Force: true,
-		})
+		log.Println("Error in waiting for container\n " + err.Error())
+		Put_Execution_Result_To_Channel(execution_channel, System_Error_Result(submission_index, "Sandbox error, try to run again"))
 	case <-time_limit_ctx.Done():
-		cli.ContainerStop(ctx, resp.ID, container.StopOptions{})
-		Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-			Submission_Index: submission_index,
-			Stdout:           "",
-			Stderr:           "Time Limit Exceeded",
-		})
-		cli.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{
-			Force: true,
-		})
+		usage := monitor.Stop()
+		cli.ContainerStop(ctx, container_id, container.StopOptions{})
+		Put_Execution_Result_To_Channel(execution_channel, Time_Limit_Result(submission_index, Message_Wall_Time_Exceeded, usage))
 	case statusCode := <-statusCh:
-		if statusCode.StatusCode == 137 {
-			Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-				Submission_Index: submission_index,
-				Stdout:           "",
-				Stderr:           "Memory Limit Exceeded",
-			})
-		}
+		usage := monitor.Stop()
 		log.Printf("Get container logs for submission %v index %v", submission_id, submission_index)
-		out, err := cli.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+		output, err := Get_Container_Output(cli, container_id)
 		if err != nil {
 			log.Println("Error in getting container logs\n " + err.Error())
-			Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-				Submission_Index: submission_index,
-				Stdout:           "",
-				Stderr:           "Something wrong " + err.Error(),
-			})
+			Put_Execution_Result_To_Channel(execution_channel, System_Error_Result(submission_index, "Failed to read the output, try to run again"))
 			return
 		}
-		stdoutput := new(bytes.Buffer)
-		stderror := new(bytes.Buffer)
-		_, err = stdcopy.StdCopy(stdoutput, stderror, out)
-		if err != nil {
-			log.Println("Error in copying container logs\n " + err.Error())
+		if cpu_limit.Killed(statusCode.StatusCode, monitor.OOM_Killed(), usage) {
+			Put_Execution_Result_To_Channel(execution_channel, Time_Limit_Result(submission_index, Message_Cpu_Time_Exceeded, usage))
+			return
 		}
-		cli.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{
-			Force: true,
-		})
 		Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
 			Submission_Index: submission_index,
-			Stdout:           stdoutput.String(),
-			Stderr:           stderror.String(),
+			Verdict:          Verdict_From_Exit(statusCode.StatusCode, monitor.OOM_Killed(), output),
+			Stdout:           output.Stdout,
+			Stderr:           output.Stderr,
+			Usage:            usage,
 		})
-		defer out.Close()
 	case <-threading_ctx.Done():
-		cli.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{
-			Force: true,
+		Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
+			Submission_Index: submission_index,
+			Verdict:          Verdict_Skipped,
+			Usage:            monitor.Stop(),
 		})
-		return
 	}
 }
 
-func compileCode(cli *client.Client, code []byte, input string, language string, time_limit int, mem_limit_mb int, submission_id string, submission_index int, execution_channel chan Execution_Result, threading_ctx context.Context) {
+// Run_Compiled runs a test case against the code compiled once in the volume
+// of its variant, see Prepare_Code_Variants.
+func Run_Compiled(cli *client.Client, volumeName string, input string, language string, time_limit int, mem_limit_mb int, cpu_limit Cpu_Limit, submission_id string, submission_index int, execution_channel chan Execution_Result, threading_ctx context.Context) {
 	ctx := context.Background()
-	volumeName := fmt.Sprintf("submission-%s-%d", submission_id, submission_index)
-	cli.VolumeCreate(ctx, volume.CreateOptions{
-		Name:   volumeName,
-		Driver: "local",
-	})
-	// Create compiling a container
-	log.Printf("Create a container for submission %v index %v", submission_id, submission_index)
-	compilingResp, err := cli.ContainerCreate(ctx, &container.Config{
-		Image:      languages_details[language]["image"],
-		Tty:        false,
-		OpenStdin:  false,
-		WorkingDir: "/app",
-		Cmd:        strings.Split(languages_details[language]["compile"], " "),
-	}, &container.HostConfig{
-		AutoRemove: false,
-		Binds:      []string{fmt.Sprintf("%s:/app", volumeName)},
-		Resources: container.Resources{
-			Ulimits: []*units.Ulimit{
-				{
-					Name: "nproc",
-					Soft: 1024,
-					Hard: 2048,
-				},
+	// Executing code
+	details, _ := Get_Language(language)
+	resources := container.Resources{
+		Ulimits: []*units.Ulimit{
+			{
+				Name: "nproc",
+				Soft: 100,
+				Hard: 1024,
 			},
-			Memory: int64(mem_limit_mb * 1024 * 1024),
 		},
-	}, nil, nil, "")
-	if err != nil {
-		log.Println("Error in creating container\n " + err.Error())
-	}
-	log.Printf("Add file to compile container for submission %v index %v", submission_id, submission_index)
-	// Copy code to container
-	content, err := Make_Archieve(fmt.Sprintf("code%s", languages_details[language]["extension"]), code)
-	if err != nil {
-		log.Println("Error in creating container\n " + err.Error())
-	}
-	err = cli.CopyToContainer(ctx, compilingResp.ID, "/app", content, types.CopyToContainerOptions{
-		AllowOverwriteDirWithFile: true,
-	})
-	if err != nil {
-		log.Println("Error in creating container\n " + err.Error())
+		Memory: int64(mem_limit_mb * 1024 * 1024),
 	}
-	// Start compiling container
-	err = cli.ContainerStart(ctx, compilingResp.ID, types.ContainerStartOptions{})
-	if err != nil {
-		log.Println("Error in starting compiling container\n " + err.Error())
-	}
-	// Wait for container to running
-	compile_time_ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
-	compilingStatusCh, compilingErrCh := cli.ContainerWait(compile_time_ctx, compilingResp.ID, container.WaitConditionNotRunning)
-	// Context with timeout
-	defer cancel()
-	select {
-	case err := <-compilingErrCh:
-		if err != nil {
-			cli.ContainerRemove(ctx, compilingResp.ID, types.ContainerRemoveOptions{
-				Force:         true,
-				RemoveVolumes: true,
-			})
-			log.Printf("Error in compiling container\n " + err.Error())
-			Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-				Submission_Index: submission_index,
-				Stdout:           "",
-				Stderr:           "Time Limit Exceeded",
-			})
-		}
-	case <-compile_time_ctx.Done():
-		cli.ContainerRemove(ctx, compilingResp.ID, types.ContainerRemoveOptions{
-			Force:         true,
-			RemoveVolumes: true,
-		})
-		Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-			Submission_Index: submission_index,
-			Stdout:           "",
-			Stderr:           "Compile Time Limit Exceeded",
-		})
-	case statusCode := <-compilingStatusCh:
-		if statusCode.StatusCode == 137 {
-			cli.ContainerRemove(ctx, compilingResp.ID, types.ContainerRemoveOptions{
-				Force:         true,
-				RemoveVolumes: true,
-			})
-			Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-				Submission_Index: submission_index,
-				Stdout:           "",
-				Stderr:           "Compile Memory Limit Exceeded",
-			})
-		}
-		if statusCode.StatusCode == 127 {
-			cli.ContainerRemove(ctx, compilingResp.ID, types.ContainerRemoveOptions{
-				Force:         true,
-				RemoveVolumes: true,
-			})
-			Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-				Submission_Index: submission_index,
-				Stdout:           "",
-				Stderr:           "Compile Error, Invalid Command",
-			})
-		}
-		if statusCode.StatusCode == 1 {
-			log.Printf("Status code: %v", statusCode.StatusCode)
-			log.Printf("Get container logs for submission %v index %v", submission_id, submission_index)
-			out, err := cli.ContainerLogs(ctx, compilingResp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
-			if err != nil {
-				log.Println("Error in getting container logs\n " + err.Error())
-				Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-					Submission_Index: submission_index,
-					Stdout:           "",
-					Stderr:           "Error in compiling container " + err.Error(),
-				})
-				return
-			}
-			stdoutput := new(bytes.Buffer)
-			stderror := new(bytes.Buffer)
-			_, err = stdcopy.StdCopy(stdoutput, stderror, out)
-			if err != nil {
-				log.Println("Error in copying container logs\n " + err.Error())
-			}
-			cli.ContainerRemove(ctx, compilingResp.ID, types.ContainerRemoveOptions{
-				Force:         true,
-				RemoveVolumes: true,
-			})
-			Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-				Submission_Index: submission_index,
-				Stdout:           stdoutput.String(),
-				Stderr:           stderror.String(),
-			})
-			defer out.Close()
-			return
-		}
-	case <-threading_ctx.Done():
-		return
-	}
-	// Executing code
-	executeResp, err := cli.ContainerCreate(ctx, &container.Config{
-		Image:      languages_details[language]["image"],
+	cpu_limit.Apply(&resources)
+	cpu_limit.Apply_Ulimit(&resources)
+	executeResp, err := Create_Container(ctx, cli, submission_id, &container.Config{
+		Image:      details.Image,
 		Tty:        false,
 		OpenStdin:  true,
 		WorkingDir: "/app",
-		Cmd:        strings.Split(languages_details[language]["execute"], " "),
+		Env:        details.Env(),
+		Cmd:        details.Run,
 	}, &container.HostConfig{
 		AutoRemove: false,
-		Binds:      []string{fmt.Sprintf("%s:/app", volumeName)},
-		Resources: container.Resources{
-			Ulimits: []*units.Ulimit{
-				{
-					Name: "nproc",
-					Soft: 100,
-					Hard: 1024,
-				},
-			},
-			Memory: int64(mem_limit_mb * 1024 * 1024),
-		},
-	}, nil, nil, "")
+		Binds:      []string{fmt.Sprintf("%s:/app:ro", volumeName)},
+		Resources:  resources,
+	})
 	if err != nil {
 		log.Println("Error in creating executing container\n " + err.Error())
+		Put_Execution_Result_To_Channel(execution_channel, System_Error_Result(submission_index, "Sandbox error, try to run again"))
+		return
 	}
+	defer Remove_Container(cli, executeResp.ID)
 	log.Printf("Start executing container for submission %v index %v", submission_id, submission_index)
 	err = cli.ContainerStart(ctx, executeResp.ID, types.ContainerStartOptions{})
 	if err != nil {
 		log.Println("Error in creating executing container\n " + err.Error())
 	}
+	monitor := Start_Usage_Monitor(cli, executeResp.ID)
 	// Write input to container
 	log.Printf("Attach executing container for submission %v index %v", submission_id, submission_index)
 	hijackedResponse, err := cli.ContainerAttach(ctx, executeResp.ID, types.ContainerAttachOptions{
@@ -722,7 +720,10 @@ func compileCode(cli *client.Client, code []byte, input string, language string,
 	})
 	if err != nil {
 		log.Println("Error in creating container\n " + err.Error())
+		Put_Execution_Result_To_Channel(execution_channel, System_Error_Result(submission_index, "Sandbox error, try to run again"))
+		return
 	}
+	defer hijackedResponse.Close()
 	log.Printf("Attach executing container for submission %v index %v", submission_id, submission_index)
 	_, err = hijackedResponse.Conn.Write([]byte(input + "\n"))
 	if err != nil {
@@ -734,87 +735,79 @@ func compileCode(cli *client.Client, code []byte, input string, language string,
 	defer cancel_execute()
 	select {
 	case err := <-executingErrCh:
-		if err != nil {
-			cli.ContainerRemove(ctx, executeResp.ID, types.ContainerRemoveOptions{
-				Force:         true,
-				RemoveVolumes: true,
-			})
-			Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-				Submission_Index: submission_index,
-				Stdout:           "",
-				Stderr:           "Sandbox error, try to run again",
-			})
+		usage := monitor.Stop()
+		if executing_time_limit_ctx.Err() != nil {
+			Put_Execution_Result_To_Channel(execution_channel, Time_Limit_Result(submission_index, Message_Wall_Time_Exceeded, usage))
+			return
 		}
+		log.Println("Error in waiting for executing container\n " + err.Error())
+		Put_Execution_Result_To_Channel(execution_channel, System_Error_Result(submission_index, "Sandbox error, try to run again"))
 	case <-executing_time_limit_ctx.Done():
-		cli.ContainerRemove(ctx, executeResp.ID, types.ContainerRemoveOptions{
-			Force:         true,
-			RemoveVolumes: true,
-		})
-		Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-			Submission_Index: submission_index,
-			Stdout:           "",
-			Stderr:           "Run Time Limit Exceeded",
-		})
+		Put_Execution_Result_To_Channel(execution_channel, Time_Limit_Result(submission_index, Message_Wall_Time_Exceeded, monitor.Stop()))
 	case statusCode := <-executingStatusCh:
-		if statusCode.StatusCode == 137 {
-			cli.ContainerRemove(ctx, executeResp.ID, types.ContainerRemoveOptions{
-				Force:         true,
-				RemoveVolumes: true,
-			})
-			Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-				Submission_Index: submission_index,
-				Stdout:           "",
-				Stderr:           "Run Time Memory Limit Exceeded",
-			})
-		}
+		usage := monitor.Stop()
 		log.Printf("Get container logs for submission %v index %v", submission_id, submission_index)
-		out, err := cli.ContainerLogs(ctx, executeResp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+		output, err := Get_Container_Output(cli, executeResp.ID)
 		if err != nil {
 			log.Println("Error in getting container logs\n " + err.Error())
-			Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-				Submission_Index: submission_index,
-				Stdout:           "",
-				Stderr:           " " + err.Error(),
-			})
+			Put_Execution_Result_To_Channel(execution_channel, System_Error_Result(submission_index, "Failed to read the output, try to run again"))
 			return
 		}
-		stdoutput := new(bytes.Buffer)
-		stderror := new(bytes.Buffer)
-		_, err = stdcopy.StdCopy(stdoutput, stderror, out)
-		if err != nil {
-			log.Println("Error in copying container logs\n " + err.Error())
+		if cpu_limit.Killed(statusCode.StatusCode, monitor.OOM_Killed(), usage) {
+			Put_Execution_Result_To_Channel(execution_channel, Time_Limit_Result(submission_index, Message_Cpu_Time_Exceeded, usage))
+			return
 		}
-		cli.ContainerRemove(ctx, executeResp.ID, types.ContainerRemoveOptions{
-			Force:         true,
-			RemoveVolumes: true,
-		})
-		cli.ContainerRemove(ctx, compilingResp.ID, types.ContainerRemoveOptions{
-			Force:         true,
-			RemoveVolumes: true,
-		})
-		cli.VolumeRemove(ctx, volumeName, true)
 		Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
 			Submission_Index: submission_index,
-			Stdout:           stdoutput.String(),
-			Stderr:           stderror.String(),
+			Verdict:          Verdict_From_Exit(statusCode.StatusCode, monitor.OOM_Killed(), output),
+			Stdout:           output.Stdout,
+			Stderr:           output.Stderr,
+			Usage:            usage,
 		})
-		defer out.Close()
 	case <-threading_ctx.Done():
-		return
+		Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
+			Submission_Index: submission_index,
+			Verdict:          Verdict_Skipped,
+			Usage:            monitor.Stop(),
+		})
 	}
 }
 
-func RunCode(threading_ctx context.Context, cli *client.Client, code []byte, input string, language string, time_limit int, mem_limit_mb int, submission_index int, execution_channel chan Execution_Result, submission_id string) {
-	if languages_details[language]["type"] == "interpreter" {
-		executeCode(cli, code, input, language, time_limit, mem_limit_mb, submission_id, submission_index, execution_channel, threading_ctx)
-	} else if languages_details[language]["type"] == "compiler" {
-		compileCode(cli, code, input, language, time_limit, mem_limit_mb, submission_id, submission_index, execution_channel, threading_ctx)
+func RunCode(threading_ctx context.Context, cli *client.Client, variant *Code_Variant, input string, language string, time_limit int, mem_limit_mb int, cpu_limit Cpu_Limit, submission_index int, execution_channel chan Execution_Result, submission_id string) {
+	details, ok := Get_Language(language)
+	if ok && !details.Is_Compiled() {
+		executeCode(cli, variant.Code, input, language, time_limit, mem_limit_mb, cpu_limit, submission_id, submission_index, execution_channel, threading_ctx)
+	} else if ok {
+		Run_Compiled(cli, variant.Volume_Name, input, language, time_limit, mem_limit_mb, cpu_limit, submission_id, submission_index, execution_channel, threading_ctx)
 	} else {
-		Put_Execution_Result_To_Channel(execution_channel, Execution_Result{
-			Submission_Index: submission_index,
-			Stdout:           "",
-			Stderr:           "Unsupported language",
-		})
+		Put_Execution_Result_To_Channel(execution_channel, System_Error_Result(submission_index, "Unsupported language"))
+	}
+}
+
+// System_Error_Result reports a test case that could not be run because of
+// the sandbox rather than the submitted code.
+func System_Error_Result(submission_index int, message string) Execution_Result {
+	return Execution_Result{
+		Submission_Index: submission_index,
+		Verdict:          Verdict_System_Error,
+		Message:          message,
+	}
+}
+
+// Verdict_From_Exit maps how a contestant container finished to a verdict. An
+// empty verdict means the output still has to be judged. Only the kernel
+// killing it for its memory is an MLE, a program can exit with 137 or be
+// killed for another reason.
+func Verdict_From_Exit(exit_code int64, oom_killed bool, output Program_Result) Verdict {
+	switch {
+	case oom_killed:
+		return Verdict_Memory_Limit_Exceeded
+	case output.Output_Exceeded:
+		return Verdict_Output_Limit_Exceeded
+	case exit_code != 0:
+		return Verdict_Runtime_Error
+	default:
+		return ""
 	}
 }
 
@@ -849,28 +842,10 @@ func Make_Archieve(filename string, data []byte) (*bytes.Reader, error) {
 	return content, nil
 }
 
-func Judge_Submission(submission_id string) (int, error) {
-	if submission_id == "" {
-		return -1, fmt.Errorf("submission_id is empty")
-	}
-	jsonBody := []byte(fmt.Sprintf(`{"submission_id": "%s"}`, submission_id))
-	bodyReader := bytes.NewReader(jsonBody)
-	req, err := http.NewRequest("POST", "http://judge.judge.svc.cluster.local/judge", bodyReader)
-	if err != nil {
-		req.Body.Close()
-		return -1, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return -1, err
-	}
-	defer resp.Body.Close()
-	return resp.StatusCode, nil
-}
-
+// SetPrefetchCount limits the unacked messages of the channel, shared by the
+// consumers of every queue.
 func SetPrefetchCount(channel *amqp.Channel, prefetch_count int) error {
-	err := channel.Qos(prefetch_count, 0, false)
+	err := channel.Qos(prefetch_count, 0, true)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
+)
+
+// Memory given to pooled containers until they are acquired and updated to the
+// memory limit of the test case.
+const pool_default_memory_limit_mb = 256
+
+// Sandbox_Pool keeps, for every language, containers that are created but not
+// started, so a test case only pays for copying its code and starting. A
+// container is used once and destroyed, the pool creates a new one in the
+// background. Only interpreted languages are pooled: compiled code runs in a
+// container that mounts the volume of its variant, which has to be known when
+// the container is created.
+type Sandbox_Pool struct {
+	cli   *client.Client
+	mutex sync.RWMutex
+	idle  map[string]chan string
+	// The CPU limit the idle containers of every language are created with
+	cpu_limits map[string]Cpu_Limit
+	// The containers being created for the pool, Close waits for them
+	refills sync.WaitGroup
+	closed  bool
+	// The containers the pool created and did not release yet, with when
+	// they were acquired, zero while they are idle
+	pooled map[string]time.Time
+}
+
+var sandbox_pool *Sandbox_Pool
+
+// Parse_Pool_Sizes parses "python=4,nodejs=2" into the pool size per language.
+func Parse_Pool_Sizes(value string) (map[string]int, error) {
+	sizes := map[string]int{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, size, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid pool size %q, expected <language>=<size>", entry)
+		}
+		number, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil || number < 0 {
+			return nil, fmt.Errorf("invalid pool size %q for language %s", size, name)
+		}
+		sizes[strings.TrimSpace(name)] = number
+	}
+	return sizes, nil
+}
+
+func New_Sandbox_Pool(cli *client.Client, languages []Language) *Sandbox_Pool {
+	pool := &Sandbox_Pool{
+		cli:        cli,
+		idle:       map[string]chan string{},
+		cpu_limits: map[string]Cpu_Limit{},
+		pooled:     map[string]time.Time{},
+	}
+	pool.Reset(languages)
+	return pool
+}
+
+// Reset destroys the idle containers, which may come from an older language
+// config, and fills the pool again with the pool size of every language.
+func (pool *Sandbox_Pool) Reset(languages []Language) {
+	idle := map[string]chan string{}
+	cpu_limits := map[string]Cpu_Limit{}
+	for _, language := range languages {
+		if language.PoolSize == 0 || language.Is_Compiled() {
+			continue
+		}
+		idle[language.Name] = make(chan string, language.PoolSize)
+		cpu_limits[language.Name] = Pool_Cpu_Limit(language)
+	}
+	pool.mutex.Lock()
+	old_idle := pool.idle
+	pool.idle = idle
+	pool.cpu_limits = cpu_limits
+	pool.mutex.Unlock()
+	for _, containers := range old_idle {
+		for len(containers) > 0 {
+			pool.Release(<-containers)
+		}
+	}
+	for language, containers := range idle {
+		for i := 0; i < cap(containers); i++ {
+			pool.start_refill(language, containers)
+		}
+	}
+}
+
+// start_refill creates a container for containers in the background, unless
+// the pool is closed.
+func (pool *Sandbox_Pool) start_refill(language string, containers chan string) {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	if pool.closed {
+		return
+	}
+	pool.refills.Add(1)
+	go pool.refill(language, containers, pool.cpu_limits[language])
+}
+
+func (pool *Sandbox_Pool) refill(language string, containers chan string, cpu_limit Cpu_Limit) {
+	defer pool.refills.Done()
+	// The memory of the test case is set when it is acquired
+	container_id, err := Create_Sandbox(pool.cli, "", language, pool_default_memory_limit_mb, cpu_limit)
+	if err != nil {
+		log.Printf("Failed to create pooled container for %s: %v", language, err)
+		return
+	}
+	if !pool.put(language, containers, container_id) {
+		// The pool was filled, reset or closed in the meantime
+		pool.Release(container_id)
+	}
+}
+
+// put adds a container to containers while they are still the idle
+// containers of language, Reset and Close wait for it so they never miss one.
+func (pool *Sandbox_Pool) put(language string, containers chan string, container_id string) bool {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.closed || pool.idle[language] != containers {
+		return false
+	}
+	select {
+	case containers <- container_id:
+		pool.pooled[container_id] = time.Time{}
+		return true
+	default:
+		return false
+	}
+}
+
+// Acquire returns a container for language with the given memory and CPU
+// limits, taken from the pool when one is idle with the same CPU time limit,
+// and created for submission_id otherwise.
+func (pool *Sandbox_Pool) Acquire(ctx context.Context, submission_id string, language string, mem_limit_mb int, cpu_limit Cpu_Limit) (string, error) {
+	pool.mutex.Lock()
+	idle, pooled := pool.idle[language]
+	container_id := ""
+	if pooled && pool.cpu_limits[language].Time_Limit == cpu_limit.Time_Limit {
+		select {
+		case container_id = <-idle:
+			pool.pooled[container_id] = time.Now()
+		default:
+		}
+	}
+	pool.mutex.Unlock()
+	if container_id != "" {
+		pool.start_refill(language, idle)
+		resources := container.Resources{
+			Memory:     int64(mem_limit_mb * 1024 * 1024),
+			MemorySwap: int64(2 * mem_limit_mb * 1024 * 1024),
+		}
+		cpu_limit.Apply(&resources)
+		_, err := pool.cli.ContainerUpdate(ctx, container_id, container.UpdateConfig{
+			Resources: resources,
+		})
+		if err == nil {
+			return container_id, nil
+		}
+		log.Printf("Failed to update pooled container for %s: %v", language, err)
+		pool.Release(container_id)
+	}
+	return Create_Sandbox(pool.cli, submission_id, language, mem_limit_mb, cpu_limit)
+}
+
+// Pool_Cpu_Limit is the CPU limit the pooled containers of language are
+// created with, the one of a test case using the limits of its language. The
+// CPUs are updated when a container is acquired, but not its RLIMIT_CPU.
+func Pool_Cpu_Limit(language Language) Cpu_Limit {
+	return Test_Cpu_Limit(language, Test_Limits{}, language.TimeLimit, -1)
+}
+
+// Release destroys a container once it ran, it is never reused.
+func (pool *Sandbox_Pool) Release(container_id string) {
+	Remove_Container(pool.cli, container_id)
+	pool.mutex.Lock()
+	delete(pool.pooled, container_id)
+	pool.mutex.Unlock()
+}
+
+// Is_Leaked tells whether a container of this worker without a submission
+// label was left behind. Pooled containers are created before the submission
+// they run for is known, so their TTL starts once they are acquired and an
+// idle one never expires.
+func (pool *Sandbox_Pool) Is_Leaked(container_id string, ttl time.Duration) bool {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+	acquired, ok := pool.pooled[container_id]
+	if !ok {
+		return true
+	}
+	return !acquired.IsZero() && time.Since(acquired) > ttl
+}
+
+// Close destroys every idle container, and the ones being created once they
+// are.
+func (pool *Sandbox_Pool) Close() {
+	pool.mutex.Lock()
+	pool.closed = true
+	idle := pool.idle
+	pool.mutex.Unlock()
+	pool.refills.Wait()
+	for _, containers := range idle {
+		for len(containers) > 0 {
+			pool.Release(<-containers)
+		}
+	}
+}
+
+// Create_Sandbox creates the container that runs interpreted code, for
+// submission_id or for the pool when it is empty.
+func Create_Sandbox(cli *client.Client, submission_id string, language_name string, mem_limit_mb int, cpu_limit Cpu_Limit) (string, error) {
+	language, ok := Get_Language(language_name)
+	if !ok {
+		return "", fmt.Errorf("unsupported language %q", language_name)
+	}
+	resources := container.Resources{
+		Ulimits: []*units.Ulimit{
+			{
+				Name: "nproc",
+				Soft: 1024,
+				Hard: 2048,
+			},
+		},
+		Memory: int64(mem_limit_mb * 1024 * 1024),
+	}
+	cpu_limit.Apply(&resources)
+	cpu_limit.Apply_Ulimit(&resources)
+	resp, err := Create_Container(context.Background(), cli, submission_id, &container.Config{
+		Image:      language.Image,
+		Tty:        false,
+		OpenStdin:  true,
+		WorkingDir: "/app",
+		Env:        language.Env(),
+		Cmd:        language.Run,
+	}, &container.HostConfig{
+		AutoRemove: false,
+		Resources:  resources,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Parse_Language_Queues parses "c=cee-compiler-queue,cpp=cee-compiler-queue"
+// into the queue of every language. The router publishes a submission to the
+// queue of its language, or to CEE_INTERPRETER_QUEUE_NAME when the language
+// is not listed, so both sides must be given the same LANGUAGE_QUEUES.
+func Parse_Language_Queues(value string) (map[string]string, error) {
+	queues := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		language, queue, found := strings.Cut(entry, "=")
+		language = strings.TrimSpace(language)
+		queue = strings.TrimSpace(queue)
+		if !found || language == "" || queue == "" {
+			return nil, fmt.Errorf("invalid language queue %q, expected <language>=<queue>", entry)
+		}
+		queues[language] = queue
+	}
+	return queues, nil
+}
+
+// Worker_Queues returns the queues this worker consumes: the queue of every
+// language it hosts. Checker and interactor programs run on the worker of the
+// submission, the router only accepts their language when it is published to
+// the same queue, which the workers of the queue host.
+func Worker_Queues(languages []Language) ([]string, error) {
+	language_queues, err := Parse_Language_Queues(os.Getenv("LANGUAGE_QUEUES"))
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	queues := []string{}
+	for _, language := range languages {
+		queue, ok := language_queues[language.Name]
+		if !ok {
+			queue = queue_name
+		}
+		if queue == "" {
+			return nil, fmt.Errorf("no queue for language %s, set CEE_INTERPRETER_QUEUE_NAME or LANGUAGE_QUEUES", language.Name)
+		}
+		if !seen[queue] {
+			seen[queue] = true
+			queues = append(queues, queue)
+		}
+	}
+	sort.Strings(queues)
+	return queues, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/redis/go-redis/v9"
+)
+
+// Labels of every container and volume cee creates. Pooled containers have no
+// submission label until they are used, which never changes their labels, so
+// they only have the worker label.
+const (
+	label_worker     = "algorint.cee.worker"
+	label_submission = "algorint.cee.submission"
+)
+
+const (
+	worker_key_prefix         = "cee-worker:"
+	worker_heartbeat_ttl      = 90 * time.Second
+	worker_heartbeat_interval = 30 * time.Second
+	reap_interval             = 5 * time.Minute
+	default_sandbox_ttl       = 30 * time.Minute
+)
+
+// worker_id tells the workers sharing a Docker host apart, a restarted worker
+// gets a new one.
+var worker_id = New_Worker_ID()
+
+func New_Worker_ID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "cee"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+func Sandbox_Labels(submission_id string) map[string]string {
+	labels := map[string]string{label_worker: worker_id}
+	if submission_id != "" {
+		labels[label_submission] = submission_id
+	}
+	return labels
+}
+
+func Worker_Key(worker string) string {
+	return worker_key_prefix + worker
+}
+
+// Keep_Worker_Alive tells the reapers of the other workers that this one is
+// alive, a worker whose key expired is dead and its sandboxes are removed.
+func Keep_Worker_Alive(redis_client *redis.Client, interval time.Duration) {
+	for {
+		err := redis_client.Set(context.Background(), Worker_Key(worker_id), time.Now().Unix(), worker_heartbeat_ttl).Err()
+		if err != nil {
+			log.Printf("Failed to send the worker heartbeat: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Sandbox_TTL reads SANDBOX_TTL, e.g. 30m. It has to stay above the time the
+// longest submission takes.
+func Sandbox_TTL() time.Duration {
+	value := os.Getenv("SANDBOX_TTL")
+	if value == "" {
+		return default_sandbox_ttl
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		log.Printf("Invalid SANDBOX_TTL %q, using %v", value, default_sandbox_ttl)
+		return default_sandbox_ttl
+	}
+	return ttl
+}
+
+// Is_Leaked_Pool_Container tells whether a pooled container of this worker,
+// one without a submission label, was left behind. Only the worker owning a
+// pool knows which of its containers are idle.
+func Is_Leaked_Pool_Container(container_id string, labels map[string]string, created time.Time, ttl time.Duration) bool {
+	if labels[label_worker] != worker_id || labels[label_submission] != "" || time.Since(created) <= ttl {
+		return false
+	}
+	return sandbox_pool == nil || sandbox_pool.Is_Leaked(container_id, ttl)
+}
+
+// Keep_Reaping removes the orphaned sandboxes every interval.
+func Keep_Reaping(cli *client.Client, redis_client *redis.Client, ttl time.Duration, interval time.Duration) {
+	for {
+		if err := Reap_Sandboxes(cli, redis_client, ttl); err != nil {
+			log.Printf("Failed to reap sandboxes: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Reap_Sandboxes removes the labelled containers and volumes that belong to a
+// dead worker, and the ones of a submission, or the pooled ones acquired, more
+// than ttl ago, which an error path left behind.
+func Reap_Sandboxes(cli *client.Client, redis_client *redis.Client, ttl time.Duration) error {
+	ctx := context.Background()
+	label_filter := filters.NewArgs(filters.Arg("label", label_worker))
+	alive := map[string]bool{worker_id: true}
+	is_orphan := func(labels map[string]string, created time.Time) bool {
+		worker := labels[label_worker]
+		if _, known := alive[worker]; !known {
+			exists, err := redis_client.Exists(ctx, Worker_Key(worker)).Result()
+			// Unknown when Redis fails, the worker is then taken as alive
+			alive[worker] = err != nil || exists == 1
+		}
+		if !alive[worker] {
+			// A worker that just started may not have sent its heartbeat yet
+			return time.Since(created) > worker_heartbeat_ttl
+		}
+		return labels[label_submission] != "" && time.Since(created) > ttl
+	}
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: label_filter})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %v", err)
+	}
+	reaped_containers := 0
+	for _, sandbox := range containers {
+		created := time.Unix(sandbox.Created, 0)
+		if is_orphan(sandbox.Labels, created) || Is_Leaked_Pool_Container(sandbox.ID, sandbox.Labels, created, ttl) {
+			log.Printf("Reaping container %s of worker %s, submission %q", sandbox.ID, sandbox.Labels[label_worker], sandbox.Labels[label_submission])
+			Remove_Container(cli, sandbox.ID)
+			reaped_containers++
+		}
+	}
+
+	volumes, err := cli.VolumeList(ctx, volume.ListOptions{Filters: label_filter})
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %v", err)
+	}
+	reaped_volumes := 0
+	for _, sandbox := range volumes.Volumes {
+		created, err := time.Parse(time.RFC3339, sandbox.CreatedAt)
+		if err != nil {
+			created = time.Now()
+		}
+		if is_orphan(sandbox.Labels, created) {
+			log.Printf("Reaping volume %s of worker %s, submission %q", sandbox.Name, sandbox.Labels[label_worker], sandbox.Labels[label_submission])
+			Remove_Volume(cli, sandbox.Name)
+			reaped_volumes++
+		}
+	}
+	if reaped_containers > 0 || reaped_volumes > 0 {
+		log.Printf("Reaped %d containers and %d volumes", reaped_containers, reaped_volumes)
+	}
+	return nil
+}
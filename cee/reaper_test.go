@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsLeakedPoolContainer(t *testing.T) {
+	ttl := 30 * time.Minute
+	old := time.Now().Add(-2 * ttl)
+	pool := sandbox_pool
+	sandbox_pool = &Sandbox_Pool{pooled: map[string]time.Time{
+		"idle":              {},
+		"acquired-recently": time.Now().Add(-time.Minute),
+		"acquired-long-ago": time.Now().Add(-2 * ttl),
+	}}
+	defer func() { sandbox_pool = pool }()
+
+	pooled_labels := map[string]string{label_worker: worker_id}
+	tests := []struct {
+		name         string
+		container_id string
+		labels       map[string]string
+		created      time.Time
+		want         bool
+	}{
+		{"idle in the pool", "idle", pooled_labels, old, false},
+		{"acquired recently", "acquired-recently", pooled_labels, old, false},
+		{"acquired more than ttl ago", "acquired-long-ago", pooled_labels, old, true},
+		{"unknown to the pool", "unknown", pooled_labels, old, true},
+		{"unknown but just created", "unknown", pooled_labels, time.Now(), false},
+		{"of a submission", "unknown", Sandbox_Labels("42"), old, false},
+		{"of another worker", "unknown", map[string]string{label_worker: "other"}, old, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Is_Leaked_Pool_Container(test.container_id, test.labels, test.created, ttl); got != test.want {
+				t.Errorf("Is_Leaked_Pool_Container(%s) = %v, want %v", test.container_id, got, test.want)
+			}
+		})
+	}
+}
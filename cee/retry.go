@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	max_delivery_attempts = 5
+	retry_delay           = 10 * time.Second
+	attempt_header        = "x-attempt"
+	reason_header         = "x-reason"
+)
+
+// Message_Error is why a message could not be handled. A retryable one, e.g.
+// Redis being unreachable, is delivered again after retry_delay, a fatal one,
+// e.g. a submission that expired, goes to the dead-letter queue.
+type Message_Error struct {
+	Retryable bool
+	Reason    string
+}
+
+func (err *Message_Error) Error() string {
+	return err.Reason
+}
+
+func Retryable_Error(format string, args ...interface{}) *Message_Error {
+	return &Message_Error{Retryable: true, Reason: fmt.Sprintf(format, args...)}
+}
+
+func Fatal_Error(format string, args ...interface{}) *Message_Error {
+	return &Message_Error{Retryable: false, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Retry_Queue holds the messages of a queue waiting to be delivered again,
+// they expire after retry_delay and are dead-lettered back to the queue.
+func Retry_Queue(queue string) string {
+	return queue + ".retry"
+}
+
+// Dead_Letter_Queue holds the messages of a queue that could not be handled,
+// with the reason in their x-reason header.
+func Dead_Letter_Queue(queue string) string {
+	return queue + ".dead-letter"
+}
+
+func Declare_Retry_Queues(ch *amqp.Channel, queue string) error {
+	_, err := ch.QueueDeclare(
+		Retry_Queue(queue),
+		true,
+		false,
+		false,
+		false,
+		amqp.Table{
+			"x-message-ttl":             int32(retry_delay / time.Millisecond),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	return Declare_MQ_Queue(ch, Dead_Letter_Queue(queue))
+}
+
+// Delivery_Attempt returns which attempt at handling the message this is,
+// starting at 1.
+func Delivery_Attempt(d *amqp.Delivery) int {
+	switch attempt := d.Headers[attempt_header].(type) {
+	case int32:
+		return int(attempt)
+	case int64:
+		return int(attempt)
+	}
+	return 1
+}
+
+// Reject_Message moves a message that failed to the retry queue, or to the
+// dead-letter queue when the failure is fatal or it was attempted
+// max_delivery_attempts times. The submission of a dead-lettered message is
+// marked as a system error so clients stop waiting for it.
+func Reject_Message(ch *amqp.Channel, redis_client *redis.Client, d *amqp.Delivery, submission_id string, message_err *Message_Error) {
+	queue := d.RoutingKey
+	attempt := Delivery_Attempt(d)
+	if message_err.Retryable && attempt >= max_delivery_attempts {
+		message_err = Fatal_Error("%s, gave up after %d attempts", message_err.Reason, attempt)
+	}
+	headers := amqp.Table{attempt_header: int32(attempt + 1)}
+	target := Retry_Queue(queue)
+	if !message_err.Retryable {
+		headers = amqp.Table{attempt_header: int32(attempt), reason_header: message_err.Reason}
+		target = Dead_Letter_Queue(queue)
+	}
+	err := ch.PublishWithContext(context.Background(), "", target, false, false, amqp.Publishing{
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Body:         d.Body,
+	})
+	if err != nil {
+		// Without a copy elsewhere the message must stay in its queue
+		log.Printf("Failed to move submission %s to %s: %v", submission_id, target, err)
+		if err := d.Nack(false, true); err != nil {
+			log.Printf("Failed to nack submission %s: %v", submission_id, err)
+		}
+		return
+	}
+	if err := d.Ack(false); err != nil {
+		log.Printf("Failed to ack submission %s: %v", submission_id, err)
+	}
+	if message_err.Retryable {
+		log.Printf("Retrying submission %s in %v, attempt %d: %s", submission_id, retry_delay, attempt+1, message_err.Reason)
+		return
+	}
+	log.Printf("Dead-lettered submission %s: %s", submission_id, message_err.Reason)
+	if submission_id != "" {
+		Mark_System_Error(redis_client, submission_id, message_err.Reason)
+	}
+}
+
+// Requeue_Message puts a message back on its queue with its attempt unchanged,
+// for a submission cee stopped judging because it is shutting down. Nacking it
+// would make it a redelivered message, which counts as a failed attempt.
+func Requeue_Message(ch *amqp.Channel, d *amqp.Delivery, submission_id string) {
+	err := ch.PublishWithContext(context.Background(), "", d.RoutingKey, false, false, amqp.Publishing{
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Headers:      d.Headers,
+		Body:         d.Body,
+	})
+	if err != nil {
+		log.Printf("Failed to requeue submission %s, it is delivered again: %v", submission_id, err)
+		if err := d.Nack(false, true); err != nil {
+			log.Printf("Failed to nack submission %s: %v", submission_id, err)
+		}
+		return
+	}
+	if err := d.Ack(false); err != nil {
+		log.Printf("Failed to ack submission %s: %v", submission_id, err)
+	}
+}
+
+// Mark_System_Error sets the status of a submission that cannot be judged to
+// "system error", keeping what can still be parsed of it, and tells its
+// listeners and its callback.
+func Mark_System_Error(redis_client *redis.Client, submission_id string, reason string) {
+	submission := Submission{SubmissionID: submission_id}
+	if result, err := Get_Submission_From_Redis(redis_client, submission_id); err == nil {
+		if parsed, err := Parse_Submission_From_Redis(result); err == nil {
+			submission = parsed
+			submission.SubmissionID = submission_id
+		}
+	}
+	submission.Status = "system error"
+	submission.Verdict = Verdict_System_Error
+	submission.Error = reason
+	if err := Save_Submission_To_Redis(submission, redis_client); err != nil {
+		log.Printf("Failed to mark submission %s as a system error: %v", submission_id, err)
+	}
+	Publish_Event(redis_client, Submission_Event{Type: Event_Judged, SubmissionID: submission_id, Verdict: Verdict_System_Error})
+	go Notify_Callback(redis_client, submission)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
+)
+
+const compile_time_limit = 5 * time.Second
+const compile_memory_limit_mb = 512
+const output_limit_bytes = 16 * 1024 * 1024
+
+// The files of a test case given to a checker or an interactor are copied to
+// judge_dir of its container, the volume of the program being shared by every
+// test case.
+const judge_dir = "/judge"
+
+type Program_Result struct {
+	Stdout    string
+	Stderr    string
+	Exit_Code int64
+	Timed_Out bool
+	// Output_Exceeded is set when stdout or stderr was cut at the output limit
+	Output_Exceeded bool
+	// OOM_Killed is set when the kernel killed the program for its memory
+	OOM_Killed bool
+}
+
+// Limited_Buffer keeps at most Limit bytes and silently drops the rest, so a
+// program printing forever cannot exhaust the memory of cee.
+type Limited_Buffer struct {
+	bytes.Buffer
+	Limit    int
+	Exceeded bool
+}
+
+func (buffer *Limited_Buffer) Write(data []byte) (int, error) {
+	remaining := buffer.Limit - buffer.Len()
+	if len(data) > remaining {
+		buffer.Exceeded = true
+		if remaining > 0 {
+			buffer.Buffer.Write(data[:remaining])
+		}
+		return len(data), nil
+	}
+	return buffer.Buffer.Write(data)
+}
+
+// Prepare_Program creates volume_name and places the code in it, then
+// compiles the code when the language needs it. A compile failure is not an
+// error, the caller has to check the returned compile result.
+func Prepare_Program(ctx context.Context, cli *client.Client, submission_id string, language string, code []byte, volume_name string) (Program_Result, error) {
+	details, ok := Get_Language(language)
+	if !ok {
+		return Program_Result{}, fmt.Errorf("unsupported language %q", language)
+	}
+	content, err := Make_Archieve(details.FileName, code)
+	if err != nil {
+		return Program_Result{}, err
+	}
+	if err := Create_Volume(ctx, cli, submission_id, volume_name); err != nil {
+		return Program_Result{}, fmt.Errorf("failed to create volume %s: %v", volume_name, err)
+	}
+	if !details.Is_Compiled() {
+		return Program_Result{}, Copy_To_Volume(ctx, cli, submission_id, details.Image, volume_name, content)
+	}
+	return Run_Sandbox_Container(ctx, cli, submission_id, details, details.Compile, volume_name, content, nil, compile_time_limit, compile_memory_limit_mb)
+}
+
+// Copy_To_Volume copies content to /app of volume_name through a container
+// that is never started.
+func Copy_To_Volume(ctx context.Context, cli *client.Client, submission_id string, image string, volume_name string, content *bytes.Reader) error {
+	resp, err := Create_Container(ctx, cli, submission_id, &container.Config{
+		Image:      image,
+		WorkingDir: "/app",
+	}, &container.HostConfig{
+		Binds: []string{fmt.Sprintf("%s:/app", volume_name)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create container: %v", err)
+	}
+	defer Remove_Container(cli, resp.ID)
+	err = cli.CopyToContainer(ctx, resp.ID, "/app", content, types.CopyToContainerOptions{
+		AllowOverwriteDirWithFile: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy files to volume: %v", err)
+	}
+	return nil
+}
+
+// Run_Program runs a helper program, such as a checker, in its own sandbox.
+// The program was prepared in volume_name with Prepare_Program, it is given
+// files in judge_dir and executed with args appended to the language execute
+// command.
+func Run_Program(ctx context.Context, cli *client.Client, submission_id string, language string, files map[string][]byte, args []string, time_limit time.Duration, mem_limit_mb int, volume_name string) (Program_Result, error) {
+	details, ok := Get_Language(language)
+	if !ok {
+		return Program_Result{}, fmt.Errorf("unsupported language %q", language)
+	}
+	command := append(append([]string{}, details.Run...), args...)
+	return Run_Sandbox_Container(ctx, cli, submission_id, details, command, volume_name, nil, files, time_limit, mem_limit_mb)
+}
+
+// Run_Sandbox_Container runs command in a container of language that mounts
+// volume_name on /app and waits for it to exit. content, when not nil, is
+// copied to /app and files to judge_dir before the container starts.
+func Run_Sandbox_Container(ctx context.Context, cli *client.Client, submission_id string, language Language, command []string, volume_name string, content *bytes.Reader, files map[string][]byte, time_limit time.Duration, mem_limit_mb int) (Program_Result, error) {
+	resp, err := Create_Container(ctx, cli, submission_id, &container.Config{
+		Image:      language.Image,
+		Tty:        false,
+		OpenStdin:  false,
+		WorkingDir: "/app",
+		Env:        language.Env(),
+		Cmd:        command,
+	}, &container.HostConfig{
+		AutoRemove: false,
+		Binds:      []string{fmt.Sprintf("%s:/app", volume_name)},
+		Resources: container.Resources{
+			Ulimits: []*units.Ulimit{
+				{
+					Name: "nproc",
+					Soft: 1024,
+					Hard: 2048,
+				},
+			},
+			Memory:   int64(mem_limit_mb * 1024 * 1024),
+			NanoCPUs: int64(Language_Cpus(language) * 1e9),
+		},
+	})
+	if err != nil {
+		return Program_Result{}, fmt.Errorf("failed to create container: %v", err)
+	}
+	defer Remove_Container(cli, resp.ID)
+	if content != nil {
+		err = cli.CopyToContainer(ctx, resp.ID, "/app", content, types.CopyToContainerOptions{
+			AllowOverwriteDirWithFile: true,
+		})
+		if err != nil {
+			return Program_Result{}, fmt.Errorf("failed to copy files to container: %v", err)
+		}
+	}
+	if files != nil {
+		if err := Copy_Judge_Files(ctx, cli, resp.ID, files); err != nil {
+			return Program_Result{}, err
+		}
+	}
+	if err = cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return Program_Result{}, fmt.Errorf("failed to start container: %v", err)
+	}
+	time_limit_ctx, cancel := context.WithTimeout(ctx, time_limit)
+	defer cancel()
+	statusCh, errCh := cli.ContainerWait(time_limit_ctx, resp.ID, container.WaitConditionNotRunning)
+	result := Program_Result{}
+	select {
+	case status := <-statusCh:
+		result.Exit_Code = status.StatusCode
+	case err := <-errCh:
+		if time_limit_ctx.Err() == nil || ctx.Err() != nil {
+			return Program_Result{}, fmt.Errorf("failed to wait for container: %v", err)
+		}
+		result.Timed_Out = true
+		cli.ContainerStop(context.Background(), resp.ID, container.StopOptions{})
+	}
+	if inspect, err := cli.ContainerInspect(context.Background(), resp.ID); err == nil && inspect.State != nil {
+		result.OOM_Killed = inspect.State.OOMKilled
+	}
+	output, err := Get_Container_Output(cli, resp.ID)
+	if err != nil {
+		return result, fmt.Errorf("failed to get container logs: %v", err)
+	}
+	result.Stdout = output.Stdout
+	result.Stderr = output.Stderr
+	result.Output_Exceeded = output.Output_Exceeded
+	return result, nil
+}
+
+// Copy_Judge_Files copies files to judge_dir of a container that was created
+// but not started.
+func Copy_Judge_Files(ctx context.Context, cli *client.Client, container_id string, files map[string][]byte) error {
+	archive_files := map[string][]byte{}
+	for name, data := range files {
+		archive_files[strings.TrimPrefix(judge_dir, "/")+"/"+name] = data
+	}
+	content, err := Make_Archieve_Files(archive_files)
+	if err != nil {
+		return err
+	}
+	err = cli.CopyToContainer(ctx, container_id, "/", content, types.CopyToContainerOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to copy files to %s: %v", judge_dir, err)
+	}
+	return nil
+}
+
+// Judge_File is the path of a file copied with Copy_Judge_Files.
+func Judge_File(name string) string {
+	return judge_dir + "/" + name
+}
+
+// Get_Container_Output reads the stdout and stderr that a container logged.
+func Get_Container_Output(cli *client.Client, container_id string) (Program_Result, error) {
+	out, err := cli.ContainerLogs(context.Background(), container_id, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return Program_Result{}, err
+	}
+	defer out.Close()
+	stdoutput := &Limited_Buffer{Limit: output_limit_bytes}
+	stderror := &Limited_Buffer{Limit: output_limit_bytes}
+	if _, err = stdcopy.StdCopy(stdoutput, stderror, out); err != nil {
+		return Program_Result{}, err
+	}
+	return Program_Result{
+		Stdout:          stdoutput.String(),
+		Stderr:          stderror.String(),
+		Output_Exceeded: stdoutput.Exceeded || stderror.Exceeded,
+	}, nil
+}
+
+// Make_Archieve_Files is Make_Archieve for several files at once.
+func Make_Archieve_Files(files map[string][]byte) (*bytes.Reader, error) {
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tarHeader := &tar.Header{
+			Name: name,
+			Mode: 0777,
+			Size: int64(len(files[name])),
+		}
+		if err := tarWriter.WriteHeader(tarHeader); err != nil {
+			return nil, err
+		}
+		if _, err := tarWriter.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf.Bytes()), nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/pbnjay/memory"
+)
+
+const (
+	// Memory a sandbox is expected to use, most test cases stay well below
+	// the memory limit of their language
+	default_slot_memory_mb = 512
+	// Memory kept for cee and Docker themselves
+	reserved_memory_mb = 512
+	// Test cases of a submission run at most in parallel, so one submission
+	// with many test cases does not hold every slot
+	max_submission_parallelism = 4
+)
+
+// Sandbox_Slots bounds the sandboxes running at once across every submission
+// of the worker. Compiling a variant, a checker or an interactor, running a
+// test case or a checker takes a slot, an interactive run takes one for each
+// of its containers. Slots are numbered, a test case may be pinned to the CPU
+// of its slot, see Slot_Cpuset.
+type Sandbox_Slots struct {
+	slots chan int
+	// Held while taking several slots, so two runs each holding some of the
+	// slots the other waits for cannot happen
+	many sync.Mutex
+}
+
+var sandbox_slots *Sandbox_Slots
+
+func New_Sandbox_Slots(size int) *Sandbox_Slots {
+	sandbox_slots := &Sandbox_Slots{slots: make(chan int, size)}
+	for slot := 0; slot < size; slot++ {
+		sandbox_slots.slots <- slot
+	}
+	return sandbox_slots
+}
+
+// Acquire waits for a free slot and returns its number, it fails when ctx is
+// done first, e.g. the test case is skipped while waiting.
+func (sandbox_slots *Sandbox_Slots) Acquire(ctx context.Context) (int, error) {
+	select {
+	case slot := <-sandbox_slots.slots:
+		return slot, nil
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+}
+
+// Acquire_Many takes count slots at once, or every slot when there are fewer.
+func (sandbox_slots *Sandbox_Slots) Acquire_Many(ctx context.Context, count int) ([]int, error) {
+	if count > sandbox_slots.Size() {
+		count = sandbox_slots.Size()
+	}
+	sandbox_slots.many.Lock()
+	defer sandbox_slots.many.Unlock()
+	slots := []int{}
+	for len(slots) < count {
+		slot, err := sandbox_slots.Acquire(ctx)
+		if err != nil {
+			sandbox_slots.Release_Many(slots)
+			return nil, err
+		}
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+func (sandbox_slots *Sandbox_Slots) Release(slot int) {
+	sandbox_slots.slots <- slot
+}
+
+func (sandbox_slots *Sandbox_Slots) Release_Many(slots []int) {
+	for _, slot := range slots {
+		sandbox_slots.Release(slot)
+	}
+}
+
+func (sandbox_slots *Sandbox_Slots) Size() int {
+	return cap(sandbox_slots.slots)
+}
+
+// Slot_Count reads SANDBOX_SLOTS, or derives the number of sandboxes the host
+// can run at once from its CPUs and its memory, each slot being given
+// SANDBOX_SLOT_MEMORY_MB (512 by default).
+func Slot_Count() int {
+	if value := os.Getenv("SANDBOX_SLOTS"); value != "" {
+		slots, err := strconv.Atoi(value)
+		if err == nil && slots > 0 {
+			return slots
+		}
+		log.Printf("Invalid SANDBOX_SLOTS %q, deriving it from the host", value)
+	}
+	slot_memory_mb := default_slot_memory_mb
+	if value := os.Getenv("SANDBOX_SLOT_MEMORY_MB"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err == nil && parsed > 0 {
+			slot_memory_mb = parsed
+		} else {
+			log.Printf("Invalid SANDBOX_SLOT_MEMORY_MB %q, using %d", value, default_slot_memory_mb)
+		}
+	}
+	total_memory_mb := int(memory.TotalMemory() / 1024 / 1024)
+	slots := runtime.NumCPU()
+	if memory_slots := (total_memory_mb - reserved_memory_mb) / slot_memory_mb; memory_slots < slots {
+		slots = memory_slots
+	}
+	if slots < 1 {
+		slots = 1
+	}
+	return slots
+}
+
+// Submission_Parallelism is how many test cases of a submission run at once.
+func Submission_Parallelism(slots int) int {
+	if slots < max_submission_parallelism {
+		return slots
+	}
+	return max_submission_parallelism
+}
+
+// Prefetch_Count is how many submissions the worker takes at once, enough to
+// keep every slot busy when each submission runs its test cases in parallel
+// and checks one output at a time with a checker program.
+func Prefetch_Count(slots int) int {
+	submission_slots := Submission_Parallelism(slots) + 1
+	prefetch := slots / submission_slots
+	if slots%submission_slots != 0 {
+		prefetch++
+	}
+	return prefetch
+}
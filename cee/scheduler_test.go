@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSlotCount(t *testing.T) {
+	t.Setenv("SANDBOX_SLOTS", "")
+	t.Setenv("SANDBOX_SLOT_MEMORY_MB", "")
+	host_slots := Slot_Count()
+	if host_slots < 1 {
+		t.Fatalf("Slot_Count() = %d from the host, want at least 1", host_slots)
+	}
+
+	tests := []struct {
+		name        string
+		slots       string
+		slot_memory string
+		want        int
+	}{
+		{"set", "3", "", 3},
+		{"set above the host", "1000", "", 1000},
+		{"zero", "0", "", host_slots},
+		{"negative", "-2", "", host_slots},
+		{"not a number", "four", "", host_slots},
+		{"invalid slot memory", "", "-1", host_slots},
+		{"slot memory above the host", "", "100000000", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SANDBOX_SLOTS", test.slots)
+			t.Setenv("SANDBOX_SLOT_MEMORY_MB", test.slot_memory)
+			if got := Slot_Count(); got != test.want {
+				t.Errorf("Slot_Count() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPrefetchCount(t *testing.T) {
+	tests := []struct {
+		slots           int
+		want_parallel   int
+		want_prefetched int
+	}{
+		{1, 1, 1},
+		{2, 2, 1},
+		{3, 3, 1},
+		{4, 4, 1},
+		{5, 4, 1},
+		{6, 4, 2},
+		{10, 4, 2},
+		{11, 4, 3},
+		{16, 4, 4},
+	}
+	for _, test := range tests {
+		if got := Submission_Parallelism(test.slots); got != test.want_parallel {
+			t.Errorf("Submission_Parallelism(%d) = %d, want %d", test.slots, got, test.want_parallel)
+		}
+		if got := Prefetch_Count(test.slots); got != test.want_prefetched {
+			t.Errorf("Prefetch_Count(%d) = %d, want %d", test.slots, got, test.want_prefetched)
+		}
+	}
+}
+
+func TestAcquireMany(t *testing.T) {
+	slots := New_Sandbox_Slots(2)
+	taken, err := slots.Acquire_Many(context.Background(), 3)
+	if err != nil || len(taken) != 2 {
+		t.Fatalf("Acquire_Many(3) = %v, %v, want every one of the 2 slots", taken, err)
+	}
+	slots.Release_Many(taken)
+
+	held, err := slots.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire() = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := slots.Acquire_Many(ctx, 2); err == nil {
+		t.Fatalf("Acquire_Many(2) succeeded while a slot is held")
+	}
+	// The slot taken before ctx was done is given back
+	slots.Release(held)
+	taken, err = slots.Acquire_Many(context.Background(), 2)
+	if err != nil || len(taken) != 2 {
+		t.Errorf("Acquire_Many(2) = %v, %v once every slot is free", taken, err)
+	}
+}
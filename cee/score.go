@@ -0,0 +1,117 @@
+package main
+
+import "sync"
+
+// Failed_Groups are the groups with a failed test case, shared between the
+// handler judging results and the loop dispatching test cases.
+type Failed_Groups struct {
+	mutex  sync.Mutex
+	groups map[string]bool
+}
+
+func (failed *Failed_Groups) Add(group_id string) {
+	failed.mutex.Lock()
+	defer failed.mutex.Unlock()
+	if failed.groups == nil {
+		failed.groups = map[string]bool{}
+	}
+	failed.groups[group_id] = true
+}
+
+func (failed *Failed_Groups) Has(group_id string) bool {
+	failed.mutex.Lock()
+	defer failed.mutex.Unlock()
+	return failed.groups[group_id]
+}
+
+// Group_Scoring returns how the group with the given ID is scored, groups
+// that are not declared in submission.Groups are all_or_nothing.
+func Group_Scoring(submission Submission, group_id string) Test_Group {
+	for _, group := range submission.Groups {
+		if group.ID == group_id {
+			if group.Scoring == "" {
+				group.Scoring = Scoring_All_Or_Nothing
+			}
+			return group
+		}
+	}
+	return Test_Group{ID: group_id, Scoring: Scoring_All_Or_Nothing}
+}
+
+// Stops_Early tells whether the remaining test cases of the group of a test
+// case that failed are skipped. Only all_or_nothing groups stop, the result
+// of the others is still worth something.
+func Stops_Early(submission Submission, group_id string) bool {
+	return group_id != "" && Group_Scoring(submission, group_id).Scoring == Scoring_All_Or_Nothing
+}
+
+// Score_Submission scores the judged test cases. A test case without a group
+// is worth its points when it passes. It returns nil when nothing is worth
+// points, e.g. for a submission in the original format.
+func Score_Submission(submission Submission) *Submission_Score {
+	score := &Submission_Score{}
+	group_tests := map[string][]Test{}
+	group_order := []string{}
+	for _, test := range submission.Tests {
+		if test.Group == "" {
+			score.MaxScore += test.Points
+			if test.Result != nil && test.Result.Passed {
+				score.Score += test.Points
+			}
+			continue
+		}
+		if _, ok := group_tests[test.Group]; !ok {
+			group_order = append(group_order, test.Group)
+		}
+		group_tests[test.Group] = append(group_tests[test.Group], test)
+	}
+	for _, group_id := range group_order {
+		group_score := Score_Group(Group_Scoring(submission, group_id), group_tests[group_id])
+		score.Score += group_score.Score
+		score.MaxScore += group_score.MaxScore
+		score.Groups = append(score.Groups, group_score)
+	}
+	if score.MaxScore == 0 {
+		return nil
+	}
+	return score
+}
+
+func Score_Group(group Test_Group, tests []Test) Group_Score {
+	// Test cases without points weigh the same
+	total_points, passed_points := 0.0, 0.0
+	for _, test := range tests {
+		total_points += test.Points
+	}
+	passed := true
+	for _, test := range tests {
+		weight := test.Points
+		if total_points == 0 {
+			weight = 1
+		}
+		if test.Result != nil && test.Result.Passed {
+			passed_points += weight
+		} else {
+			passed = false
+		}
+	}
+	group_score := Group_Score{
+		ID:       group.ID,
+		MaxScore: group.Points,
+		Passed:   passed,
+	}
+	if group_score.MaxScore == 0 {
+		group_score.MaxScore = total_points
+	}
+	switch {
+	case passed:
+		group_score.Score = group_score.MaxScore
+	case group.Scoring == Scoring_Proportional && len(tests) > 0:
+		weights := total_points
+		if weights == 0 {
+			weights = float64(len(tests))
+		}
+		group_score.Score = group_score.MaxScore * passed_points / weights
+	}
+	return group_score
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func Passed_Test(group string, points float64) Test {
+	return Test{Group: group, Points: points, Result: &Test_Result{Verdict: Verdict_Accepted, Passed: true}}
+}
+
+func Failed_Test(group string, points float64) Test {
+	return Test{Group: group, Points: points, Result: &Test_Result{Verdict: Verdict_Wrong_Answer}}
+}
+
+func TestScoreGroup(t *testing.T) {
+	tests := []struct {
+		name  string
+		group Test_Group
+		tests []Test
+		want  Group_Score
+	}{
+		{
+			name:  "all or nothing passed",
+			group: Test_Group{ID: "1", Scoring: Scoring_All_Or_Nothing},
+			tests: []Test{Passed_Test("1", 10), Passed_Test("1", 20)},
+			want:  Group_Score{ID: "1", Score: 30, MaxScore: 30, Passed: true},
+		},
+		{
+			name:  "all or nothing failed",
+			group: Test_Group{ID: "1", Scoring: Scoring_All_Or_Nothing},
+			tests: []Test{Passed_Test("1", 10), Failed_Test("1", 20)},
+			want:  Group_Score{ID: "1", Score: 0, MaxScore: 30},
+		},
+		{
+			name:  "all or nothing skipped test case",
+			group: Test_Group{ID: "1", Scoring: Scoring_All_Or_Nothing},
+			tests: []Test{Failed_Test("1", 10), {Group: "1", Points: 20, Result: &Test_Result{Verdict: Verdict_Skipped}}},
+			want:  Group_Score{ID: "1", Score: 0, MaxScore: 30},
+		},
+		{
+			name:  "proportional passed",
+			group: Test_Group{ID: "1", Scoring: Scoring_Proportional},
+			tests: []Test{Passed_Test("1", 10), Passed_Test("1", 20)},
+			want:  Group_Score{ID: "1", Score: 30, MaxScore: 30, Passed: true},
+		},
+		{
+			name:  "proportional by points",
+			group: Test_Group{ID: "1", Scoring: Scoring_Proportional},
+			tests: []Test{Passed_Test("1", 10), Failed_Test("1", 30)},
+			want:  Group_Score{ID: "1", Score: 10, MaxScore: 40},
+		},
+		{
+			name:  "proportional scaled to the group points",
+			group: Test_Group{ID: "1", Scoring: Scoring_Proportional, Points: 100},
+			tests: []Test{Passed_Test("1", 1), Failed_Test("1", 3)},
+			want:  Group_Score{ID: "1", Score: 25, MaxScore: 100},
+		},
+		{
+			name:  "proportional test cases without points weigh the same",
+			group: Test_Group{ID: "1", Scoring: Scoring_Proportional, Points: 60},
+			tests: []Test{Passed_Test("1", 0), Passed_Test("1", 0), Failed_Test("1", 0)},
+			want:  Group_Score{ID: "1", Score: 40, MaxScore: 60},
+		},
+		{
+			name:  "proportional test case without a result",
+			group: Test_Group{ID: "1", Scoring: Scoring_Proportional},
+			tests: []Test{Passed_Test("1", 5), {Group: "1", Points: 5}},
+			want:  Group_Score{ID: "1", Score: 5, MaxScore: 10},
+		},
+		{
+			name:  "proportional nothing passed",
+			group: Test_Group{ID: "1", Scoring: Scoring_Proportional},
+			tests: []Test{Failed_Test("1", 5), Failed_Test("1", 5)},
+			want:  Group_Score{ID: "1", Score: 0, MaxScore: 10},
+		},
+		{
+			name:  "group points override the test cases",
+			group: Test_Group{ID: "1", Scoring: Scoring_All_Or_Nothing, Points: 50},
+			tests: []Test{Passed_Test("1", 10), Passed_Test("1", 20)},
+			want:  Group_Score{ID: "1", Score: 50, MaxScore: 50, Passed: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Score_Group(test.group, test.tests)
+			if math.Abs(got.Score-test.want.Score) < 1e-9 {
+				got.Score = test.want.Score
+			}
+			if got != test.want {
+				t.Errorf("Score_Group() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScoreSubmission(t *testing.T) {
+	tests := []struct {
+		name       string
+		submission Submission
+		want       *Submission_Score
+	}{
+		{
+			name: "undeclared groups are all or nothing",
+			submission: Submission{Tests: []Test{
+				Passed_Test("a", 10), Failed_Test("a", 10),
+				Passed_Test("b", 5),
+			}},
+			want: &Submission_Score{Score: 5, MaxScore: 25, Groups: []Group_Score{
+				{ID: "a", Score: 0, MaxScore: 20},
+				{ID: "b", Score: 5, MaxScore: 5, Passed: true},
+			}},
+		},
+		{
+			name: "declared proportional group",
+			submission: Submission{
+				Groups: []Test_Group{{ID: "a", Scoring: Scoring_Proportional}},
+				Tests:  []Test{Passed_Test("a", 10), Failed_Test("a", 10)},
+			},
+			want: &Submission_Score{Score: 10, MaxScore: 20, Groups: []Group_Score{
+				{ID: "a", Score: 10, MaxScore: 20},
+			}},
+		},
+		{
+			name: "test cases without a group",
+			submission: Submission{Tests: []Test{
+				Passed_Test("", 3), Failed_Test("", 4), Passed_Test("a", 5),
+			}},
+			want: &Submission_Score{Score: 8, MaxScore: 12, Groups: []Group_Score{
+				{ID: "a", Score: 5, MaxScore: 5, Passed: true},
+			}},
+		},
+		{
+			name: "groups in the order of their first test case",
+			submission: Submission{Tests: []Test{
+				Passed_Test("b", 1), Passed_Test("a", 1), Passed_Test("b", 1),
+			}},
+			want: &Submission_Score{Score: 3, MaxScore: 3, Groups: []Group_Score{
+				{ID: "b", Score: 2, MaxScore: 2, Passed: true},
+				{ID: "a", Score: 1, MaxScore: 1, Passed: true},
+			}},
+		},
+		{
+			name:       "nothing worth points",
+			submission: Submission{Tests: []Test{Passed_Test("", 0), Failed_Test("", 0)}},
+			want:       nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Score_Submission(test.submission); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Score_Submission() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStopsEarly(t *testing.T) {
+	submission := Submission{Groups: []Test_Group{
+		{ID: "proportional", Scoring: Scoring_Proportional},
+		{ID: "declared", Scoring: Scoring_All_Or_Nothing},
+		{ID: "default"},
+	}}
+	tests := []struct {
+		group_id string
+		want     bool
+	}{
+		{"proportional", false},
+		{"declared", true},
+		{"default", true},
+		{"undeclared", true},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := Stops_Early(submission, test.group_id); got != test.want {
+			t.Errorf("Stops_Early(%q) = %v, want %v", test.group_id, got, test.want)
+		}
+	}
+}
+
+func TestFailedGroups(t *testing.T) {
+	failed := &Failed_Groups{}
+	if failed.Has("a") {
+		t.Errorf("Has(a) = true before any failure")
+	}
+	failed.Add("a")
+	if !failed.Has("a") {
+		t.Errorf("Has(a) = false once added")
+	}
+	if failed.Has("b") {
+		t.Errorf("Has(b) = true, only a failed")
+	}
+}
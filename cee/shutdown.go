@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// Time given to the submissions being judged to finish once cee is asked to
+// stop, the ones still running after it are requeued. It has to stay below
+// the terminationGracePeriodSeconds of the deployment.
+const default_drain_timeout = 60 * time.Second
+
+// Time given to the aborted submissions to requeue their message.
+const abort_timeout = 10 * time.Second
+
+// In_Flight counts the messages being handled, so a shutdown can wait for
+// them. Once it drains no message is handled anymore.
+type In_Flight struct {
+	mutex    sync.Mutex
+	count    int
+	draining bool
+	idle     chan struct{}
+}
+
+var in_flight = &In_Flight{idle: make(chan struct{})}
+
+// abort_ctx is cancelled when the drain timed out, the submissions still being
+// judged then stop and requeue their message instead of saving a result.
+var abort_ctx, abort_in_flight = context.WithCancel(context.Background())
+
+var drain_once sync.Once
+
+// Start tells whether a message can be handled, Done must be called once it
+// was.
+func (handlers *In_Flight) Start() bool {
+	handlers.mutex.Lock()
+	defer handlers.mutex.Unlock()
+	if handlers.draining {
+		return false
+	}
+	handlers.count++
+	return true
+}
+
+func (handlers *In_Flight) Done() {
+	handlers.mutex.Lock()
+	defer handlers.mutex.Unlock()
+	handlers.count--
+	if handlers.count == 0 && handlers.draining {
+		close(handlers.idle)
+	}
+}
+
+// Drain stops new messages from being handled and returns a channel closed
+// once the ones being handled are done. It must be called once.
+func (handlers *In_Flight) Drain() <-chan struct{} {
+	handlers.mutex.Lock()
+	defer handlers.mutex.Unlock()
+	handlers.draining = true
+	if handlers.count == 0 {
+		close(handlers.idle)
+	}
+	return handlers.idle
+}
+
+// Drain_Timeout reads DRAIN_TIMEOUT, e.g. 60s.
+func Drain_Timeout() time.Duration {
+	value := os.Getenv("DRAIN_TIMEOUT")
+	if value == "" {
+		return default_drain_timeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		log.Printf("Invalid DRAIN_TIMEOUT %q, using %v", value, default_drain_timeout)
+		return default_drain_timeout
+	}
+	return timeout
+}
+
+// Drain_Handlers waits for the messages being handled, aborting the ones
+// still running after timeout so they requeue their message. Only the first
+// call drains, the next ones return at once.
+func Drain_Handlers(timeout time.Duration) {
+	drain_once.Do(func() { drain_handlers(timeout) })
+}
+
+func drain_handlers(timeout time.Duration) {
+	idle := in_flight.Drain()
+	log.Printf("Waiting up to %v for the submissions being judged", timeout)
+	select {
+	case <-idle:
+		log.Printf("Every submission being judged is done")
+		return
+	case <-time.After(timeout):
+	}
+	log.Printf("Drain timed out, requeueing the submissions still being judged")
+	abort_in_flight()
+	select {
+	case <-idle:
+	case <-time.After(abort_timeout):
+		log.Printf("Submissions still running after the abort, RabbitMQ requeues them when the connection closes")
+	}
+}
+
+// Sandbox_Tracker records the containers and volumes cee created and did not
+// remove yet, so the ones left by a shutdown are removed too.
+type Sandbox_Tracker struct {
+	mutex      sync.Mutex
+	containers map[string]bool
+	volumes    map[string]bool
+}
+
+var sandboxes = &Sandbox_Tracker{
+	containers: map[string]bool{},
+	volumes:    map[string]bool{},
+}
+
+func Create_Container(ctx context.Context, cli *client.Client, submission_id string, config *container.Config, host_config *container.HostConfig) (container.CreateResponse, error) {
+	config.Labels = Sandbox_Labels(submission_id)
+	resp, err := cli.ContainerCreate(ctx, config, host_config, nil, nil, "")
+	if err != nil {
+		return resp, err
+	}
+	sandboxes.mutex.Lock()
+	sandboxes.containers[resp.ID] = true
+	sandboxes.mutex.Unlock()
+	return resp, nil
+}
+
+func Remove_Container(cli *client.Client, container_id string) {
+	cli.ContainerRemove(context.Background(), container_id, types.ContainerRemoveOptions{
+		Force: true,
+	})
+	sandboxes.mutex.Lock()
+	delete(sandboxes.containers, container_id)
+	sandboxes.mutex.Unlock()
+}
+
+func Create_Volume(ctx context.Context, cli *client.Client, submission_id string, volume_name string) error {
+	_, err := cli.VolumeCreate(ctx, volume.CreateOptions{
+		Name:   volume_name,
+		Driver: "local",
+		Labels: Sandbox_Labels(submission_id),
+	})
+	if err != nil {
+		return err
+	}
+	sandboxes.mutex.Lock()
+	sandboxes.volumes[volume_name] = true
+	sandboxes.mutex.Unlock()
+	return nil
+}
+
+func Remove_Volume(cli *client.Client, volume_name string) {
+	cli.VolumeRemove(context.Background(), volume_name, true)
+	sandboxes.mutex.Lock()
+	delete(sandboxes.volumes, volume_name)
+	sandboxes.mutex.Unlock()
+}
+
+// Remove_All removes every container and volume left, containers first since
+// a volume in use cannot be removed.
+func (tracker *Sandbox_Tracker) Remove_All(cli *client.Client) {
+	tracker.mutex.Lock()
+	containers := make([]string, 0, len(tracker.containers))
+	for container_id := range tracker.containers {
+		containers = append(containers, container_id)
+	}
+	volumes := make([]string, 0, len(tracker.volumes))
+	for volume_name := range tracker.volumes {
+		volumes = append(volumes, volume_name)
+	}
+	tracker.mutex.Unlock()
+	for _, container_id := range containers {
+		Remove_Container(cli, container_id)
+	}
+	for _, volume_name := range volumes {
+		Remove_Volume(cli, volume_name)
+	}
+	log.Printf("Removed %d containers and %d volumes left", len(containers), len(volumes))
+}
@@ -17,8 +17,7 @@ while true; do
     fi
 done
 
-# Run your executable "app" here
+# Run your executable "app" here, replacing the shell so it gets the SIGTERM
+# of the container
 echo "Running app..."
-/app
-
-echo "Script execution completed."
+exec /app
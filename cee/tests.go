@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Is_Legacy tells whether the submission uses the parallel arrays instead of
+// Tests. Its results are then written back to the arrays.
+func Is_Legacy(submission Submission) bool {
+	return len(submission.Tests) == 0
+}
+
+// Tests_From_Legacy builds the Tests of a submission in the parallel array
+// format, one per stdin. The router validates submissions, an error only
+// keeps a malformed one from crashing the worker: the tests are still built
+// so each of them can get a system error.
+func Tests_From_Legacy(submission *Submission) error {
+	test_count := len(submission.Stdin)
+	tests := make([]Test, test_count)
+	for index := range tests {
+		tests[index].Stdin = submission.Stdin[index]
+		if index < len(submission.TestCases) {
+			tests[index].Expected = submission.TestCases[index]
+		}
+		if index < len(submission.TimeLimit) {
+			tests[index].Limits.TimeLimit = submission.TimeLimit[index]
+		}
+		if index < len(submission.MemoryLimit) {
+			tests[index].Limits.MemoryLimit = submission.MemoryLimit[index]
+		}
+		if index < len(submission.Replace) {
+			tests[index].Replace = submission.Replace[index]
+		}
+		if index < len(submission.Comparators) {
+			tests[index].Comparator = submission.Comparators[index]
+		}
+	}
+	submission.Tests = tests
+	if len(submission.TestCases) != test_count || len(submission.TimeLimit) != test_count || len(submission.MemoryLimit) != test_count {
+		return fmt.Errorf("test_cases, time_limit and memory_limit must have one item per test case")
+	}
+	return nil
+}
+
+// Legacy_From_Tests writes the results of the Tests to the parallel arrays and
+// drops the Tests, so the submission is saved in the format it came in.
+func Legacy_From_Tests(submission *Submission) {
+	test_count := len(submission.Tests)
+	submission.Stdout = make([]string, test_count)
+	submission.Stderr = make([]string, test_count)
+	submission.Result = make([]bool, test_count)
+	submission.Verdicts = make([]Verdict, test_count)
+	submission.Usage = make([]Usage, test_count)
+	messages := make([]string, test_count)
+	checker_errors := make([]string, test_count)
+	submission.Messages = nil
+	submission.CheckerErrors = nil
+	for index, test := range submission.Tests {
+		if test.Result == nil {
+			continue
+		}
+		submission.Stdout[index] = test.Result.Stdout
+		submission.Stderr[index] = test.Result.Stderr
+		submission.Result[index] = test.Result.Passed
+		submission.Verdicts[index] = test.Result.Verdict
+		submission.Usage[index] = test.Result.Usage
+		messages[index] = test.Result.Message
+		checker_errors[index] = test.Result.CheckerError
+		if test.Result.Message != "" {
+			submission.Messages = messages
+		}
+		if test.Result.CheckerError != "" {
+			submission.CheckerErrors = checker_errors
+		}
+	}
+	submission.Tests = nil
+}
+
+// Test_Verdicts returns the verdict of every test case, empty for the ones
+// without a result.
+func Test_Verdicts(tests []Test) []Verdict {
+	verdicts := make([]Verdict, len(tests))
+	for index, test := range tests {
+		if test.Result != nil {
+			verdicts[index] = test.Result.Verdict
+		}
+	}
+	return verdicts
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTestsFromLegacy(t *testing.T) {
+	tests := []struct {
+		name       string
+		submission Submission
+		want       []Test
+		want_err   bool
+	}{
+		{
+			name: "one test per stdin",
+			submission: Submission{
+				Stdin:       []string{"aW4x", "aW4y"},
+				TestCases:   []string{"b3V0MQ==", "b3V0Mg=="},
+				TimeLimit:   []int{1, 2},
+				MemoryLimit: []int{64, 128},
+				Replace:     [][]Replace_Rule{{{From: "YQ==", To: "Yg=="}}, nil},
+				Comparators: []Comparator{{Type: "token"}, {Type: "float", AbsoluteEpsilon: 1e-6}},
+			},
+			want: []Test{
+				{
+					Stdin:      "aW4x",
+					Expected:   "b3V0MQ==",
+					Limits:     Test_Limits{TimeLimit: 1, MemoryLimit: 64},
+					Replace:    []Replace_Rule{{From: "YQ==", To: "Yg=="}},
+					Comparator: Comparator{Type: "token"},
+				},
+				{
+					Stdin:      "aW4y",
+					Expected:   "b3V0Mg==",
+					Limits:     Test_Limits{TimeLimit: 2, MemoryLimit: 128},
+					Comparator: Comparator{Type: "float", AbsoluteEpsilon: 1e-6},
+				},
+			},
+		},
+		{
+			name: "optional arrays left out",
+			submission: Submission{
+				Stdin:       []string{"aW4x"},
+				TestCases:   []string{"b3V0MQ=="},
+				TimeLimit:   []int{1},
+				MemoryLimit: []int{64},
+			},
+			want: []Test{
+				{Stdin: "aW4x", Expected: "b3V0MQ==", Limits: Test_Limits{TimeLimit: 1, MemoryLimit: 64}},
+			},
+		},
+		{
+			name: "missing limits still build every test",
+			submission: Submission{
+				Stdin:       []string{"aW4x", "aW4y"},
+				TestCases:   []string{"b3V0MQ==", "b3V0Mg=="},
+				TimeLimit:   []int{1},
+				MemoryLimit: []int{64, 128},
+			},
+			want: []Test{
+				{Stdin: "aW4x", Expected: "b3V0MQ==", Limits: Test_Limits{TimeLimit: 1, MemoryLimit: 64}},
+				{Stdin: "aW4y", Expected: "b3V0Mg==", Limits: Test_Limits{MemoryLimit: 128}},
+			},
+			want_err: true,
+		},
+		{
+			name: "extra expected output",
+			submission: Submission{
+				Stdin:       []string{"aW4x"},
+				TestCases:   []string{"b3V0MQ==", "b3V0Mg=="},
+				TimeLimit:   []int{1},
+				MemoryLimit: []int{64},
+			},
+			want: []Test{
+				{Stdin: "aW4x", Expected: "b3V0MQ==", Limits: Test_Limits{TimeLimit: 1, MemoryLimit: 64}},
+			},
+			want_err: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			submission := test.submission
+			err := Tests_From_Legacy(&submission)
+			if (err != nil) != test.want_err {
+				t.Errorf("Tests_From_Legacy() error = %v, want error %v", err, test.want_err)
+			}
+			if !reflect.DeepEqual(submission.Tests, test.want) {
+				t.Errorf("Tests = %+v, want %+v", submission.Tests, test.want)
+			}
+		})
+	}
+}
+
+func TestLegacyFromTests(t *testing.T) {
+	usage := Usage{WallTime: 12, CpuTime: 10, PeakMemory: 2048}
+	tests := []struct {
+		name  string
+		tests []Test
+		want  Submission
+	}{
+		{
+			name: "results written to the arrays",
+			tests: []Test{
+				{Result: &Test_Result{Verdict: Verdict_Accepted, Passed: true, Stdout: "b3V0MQ==", Usage: usage}},
+				{Result: &Test_Result{Verdict: Verdict_Wrong_Answer, Stdout: "b3V0", Stderr: "ZXJy"}},
+			},
+			want: Submission{
+				Stdout:   []string{"b3V0MQ==", "b3V0"},
+				Stderr:   []string{"", "ZXJy"},
+				Result:   []bool{true, false},
+				Verdicts: []Verdict{Verdict_Accepted, Verdict_Wrong_Answer},
+				Usage:    []Usage{usage, {}},
+			},
+		},
+		{
+			name: "messages and checker errors only when one is set",
+			tests: []Test{
+				{Result: &Test_Result{Verdict: Verdict_Accepted, Passed: true}},
+				{Result: &Test_Result{Verdict: Verdict_System_Error, Message: "sandbox failed", CheckerError: "checker crashed"}},
+			},
+			want: Submission{
+				Stdout:        []string{"", ""},
+				Stderr:        []string{"", ""},
+				Result:        []bool{true, false},
+				Verdicts:      []Verdict{Verdict_Accepted, Verdict_System_Error},
+				Usage:         []Usage{{}, {}},
+				Messages:      []string{"", "sandbox failed"},
+				CheckerErrors: []string{"", "checker crashed"},
+			},
+		},
+		{
+			name:  "test without a result",
+			tests: []Test{{}},
+			want: Submission{
+				Stdout:   []string{""},
+				Stderr:   []string{""},
+				Result:   []bool{false},
+				Verdicts: []Verdict{""},
+				Usage:    []Usage{{}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			submission := Submission{Tests: test.tests, Messages: []string{"stale"}}
+			Legacy_From_Tests(&submission)
+			if !reflect.DeepEqual(submission, test.want) {
+				t.Errorf("Legacy_From_Tests() = %+v, want %+v", submission, test.want)
+			}
+		})
+	}
+}
+
+// A legacy submission is judged as Tests and saved back in the format it came
+// in, with one result per test case in the order of its stdin.
+func TestLegacyRoundTrip(t *testing.T) {
+	submission := Submission{
+		Stdin:       []string{"aW4x", "aW4y", "aW4z"},
+		TestCases:   []string{"b3V0MQ==", "b3V0Mg==", "b3V0Mw=="},
+		TimeLimit:   []int{1, 2, 3},
+		MemoryLimit: []int{64, 128, 256},
+		Comparators: []Comparator{{}, {Type: "token"}, {Type: "line_set"}},
+	}
+	if err := Tests_From_Legacy(&submission); err != nil {
+		t.Fatalf("Tests_From_Legacy() = %v", err)
+	}
+	verdicts := []Verdict{Verdict_Accepted, Verdict_Wrong_Answer, Verdict_Time_Limit_Exceeded}
+	for index := range submission.Tests {
+		submission.Tests[index].Result = &Test_Result{
+			Verdict: verdicts[index],
+			Passed:  verdicts[index] == Verdict_Accepted,
+			Stdout:  submission.Tests[index].Stdin,
+		}
+	}
+	Legacy_From_Tests(&submission)
+
+	if submission.Tests != nil {
+		t.Errorf("Tests = %+v, want none once written back", submission.Tests)
+	}
+	if !reflect.DeepEqual(submission.Verdicts, verdicts) {
+		t.Errorf("Verdicts = %v, want %v", submission.Verdicts, verdicts)
+	}
+	if want := []bool{true, false, false}; !reflect.DeepEqual(submission.Result, want) {
+		t.Errorf("Result = %v, want %v", submission.Result, want)
+	}
+	if !reflect.DeepEqual(submission.Stdout, submission.Stdin) {
+		t.Errorf("Stdout = %v, want the output of each stdin %v", submission.Stdout, submission.Stdin)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(submission.TimeLimit, want) {
+		t.Errorf("TimeLimit = %v, want it untouched %v", submission.TimeLimit, want)
+	}
+	if submission.Messages != nil || submission.CheckerErrors != nil {
+		t.Errorf("Messages = %v, CheckerErrors = %v, want none", submission.Messages, submission.CheckerErrors)
+	}
+	if !Is_Legacy(submission) {
+		t.Errorf("Is_Legacy() = false, want the legacy format back")
+	}
+}
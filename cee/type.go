@@ -5,26 +5,177 @@ type Submission_Token struct {
 }
 
 type Submission struct {
-	Status    string   `json:"status"`
-	Language  string   `json:"language"`
-	Code      string   `json:"code"`
-	Stdin     []string `json:"stdin"`
-	TestCases []string `json:"test_cases"`
-	Replace   [][]struct {
-		From string `json:"from"`
-		To   string `json:"to"`
-		ID   string `json:"_id,omitempty"`
-	} `json:"replace"`
-	SubmissionID string   `json:"submission_id"`
-	MemoryLimit  []int    `json:"memory_limit"`
-	TimeLimit    []int    `json:"time_limit"`
-	Stdout       []string `json:"stdout,omitempty"`
-	Stderr       []string `json:"stderr,omitempty"`
-	Result       []bool   `json:"result,omitempty"`
+	Status       string           `json:"status"`
+	Language     string           `json:"language"`
+	Code         string           `json:"code"`
+	Stdin        []string         `json:"stdin"`
+	TestCases    []string         `json:"test_cases"`
+	Replace      [][]Replace_Rule `json:"replace"`
+	SubmissionID string           `json:"submission_id"`
+	MemoryLimit  []int            `json:"memory_limit"`
+	TimeLimit    []int            `json:"time_limit"`
+	Stdout       []string         `json:"stdout,omitempty"`
+	Stderr       []string         `json:"stderr,omitempty"`
+	Result       []bool           `json:"result,omitempty"`
+	Comparators  []Comparator     `json:"comparators,omitempty"`
+	// CheckerErrors holds, per test case, why a checker program failed. They
+	// are kept apart from Stderr, which only belongs to the contestant.
+	CheckerErrors []string    `json:"checker_errors,omitempty"`
+	Interactor    *Interactor `json:"interactor,omitempty"`
+	// Verdicts holds the verdict of every test case and Verdict the first one
+	// that is not accepted. Messages explains verdicts that are not caused by
+	// the contestant output, such as a sandbox error.
+	Verdicts []Verdict `json:"verdicts,omitempty"`
+	Verdict  Verdict   `json:"verdict,omitempty"`
+	Messages []string  `json:"messages,omitempty"`
+	Usage    []Usage   `json:"usage,omitempty"`
+	// CompileOutput is what the compiler printed when the code did not compile
+	CompileOutput string `json:"compile_output,omitempty"`
+	// Tests is the v2 format, one item per test case with its input, limits
+	// and result. A submission uses either Tests or the parallel arrays above,
+	// which are converted to Tests when the submission is handled.
+	Tests []Test `json:"tests,omitempty"`
+	// Groups sets how the groups of the Tests are scored, and Score is the
+	// result. Both only exist in the v2 format.
+	Groups []Test_Group      `json:"groups,omitempty"`
+	Score  *Submission_Score `json:"score,omitempty"`
+	// StopOnFirstFailure skips every test case left once one fails, the ones
+	// running are killed.
+	StopOnFirstFailure bool `json:"stop_on_first_failure,omitempty"`
+	// Error is why a submission with the "system error" status could not be
+	// judged at all.
+	Error string `json:"error,omitempty"`
+}
+
+type Replace_Rule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	ID   string `json:"_id,omitempty"`
+}
+
+// Test is a test case of a v2 submission. Stdin, Expected and the Replace
+// rules are base64 encoded. Result is set once the submission is judged.
+type Test struct {
+	ID         string         `json:"id,omitempty"`
+	Stdin      string         `json:"stdin"`
+	Expected   string         `json:"expected"`
+	Replace    []Replace_Rule `json:"replace,omitempty"`
+	Limits     Test_Limits    `json:"limits"`
+	Comparator Comparator     `json:"comparator,omitempty"`
+	// Group is the ID of the group (subtask) of the test case and Points what
+	// it is worth.
+	Group  string       `json:"group,omitempty"`
+	Points float64      `json:"points,omitempty"`
+	Result *Test_Result `json:"result,omitempty"`
+}
+
+const (
+	Scoring_All_Or_Nothing = "all_or_nothing"
+	Scoring_Proportional   = "proportional"
+)
+
+// Test_Group is a group of test cases scored together. An all_or_nothing
+// group, the default, is worth its points only when every test case passes
+// and stops running its test cases at the first failure. A proportional group
+// is worth the share of the points of its test cases that passed. Points
+// overrides the sum of the points of the test cases.
+type Test_Group struct {
+	ID      string  `json:"id"`
+	Scoring string  `json:"scoring,omitempty"`
+	Points  float64 `json:"points,omitempty"`
+}
+
+type Submission_Score struct {
+	Score    float64       `json:"score"`
+	MaxScore float64       `json:"max_score"`
+	Groups   []Group_Score `json:"groups,omitempty"`
+}
+
+type Group_Score struct {
+	ID       string  `json:"id"`
+	Score    float64 `json:"score"`
+	MaxScore float64 `json:"max_score"`
+	Passed   bool    `json:"passed"`
+}
+
+// Test_Limits are in seconds and megabytes, 0 uses the default of the language.
+// TimeLimit limits the wall time and CpuTimeLimit the CPU time, Cpus is the
+// share of CPUs the test case gets.
+type Test_Limits struct {
+	TimeLimit    int     `json:"time_limit"`
+	MemoryLimit  int     `json:"memory_limit"`
+	CpuTimeLimit int     `json:"cpu_time_limit,omitempty"`
+	Cpus         float64 `json:"cpus,omitempty"`
+}
+
+// Test_Result is the outcome of a test case, Stdout and Stderr are base64
+// encoded.
+type Test_Result struct {
+	Verdict      Verdict `json:"verdict"`
+	Passed       bool    `json:"passed"`
+	Message      string  `json:"message,omitempty"`
+	Stdout       string  `json:"stdout"`
+	Stderr       string  `json:"stderr"`
+	Usage        Usage   `json:"usage"`
+	CheckerError string  `json:"checker_error,omitempty"`
 }
 
 type Execution_Result struct {
 	Submission_Index int
-	Stdout           string
-	Stderr           string
+	// Verdict is left empty when the output still has to be judged
+	Verdict Verdict
+	Message string
+	Stdout  string
+	Stderr  string
+	Usage   Usage
+	// Set when the interactor of an interactive run failed
+	Checker_Error string
+}
+
+type Comparator struct {
+	Type            string  `json:"type,omitempty"`
+	AbsoluteEpsilon float64 `json:"absolute_epsilon,omitempty"`
+	RelativeEpsilon float64 `json:"relative_epsilon,omitempty"`
+	// Language, Code and VerdictFrom describe a checker program, used when
+	// Type is "program".
+	Language    string `json:"language,omitempty"`
+	Code        string `json:"code,omitempty"`
+	VerdictFrom string `json:"verdict_from,omitempty"`
+}
+
+// Interactor is a program that talks to the contestant program through its
+// stdin and stdout and decides the verdict of an interactive problem.
+type Interactor struct {
+	Language   string `json:"language"`
+	Code       string `json:"code"`
+	QueryLimit int    `json:"query_limit,omitempty"`
+}
+
+// Verdict is the outcome of a single test case or of a whole submission.
+type Verdict string
+
+const (
+	Verdict_Accepted              Verdict = "AC"
+	Verdict_Wrong_Answer          Verdict = "WA"
+	Verdict_Time_Limit_Exceeded   Verdict = "TLE"
+	Verdict_Memory_Limit_Exceeded Verdict = "MLE"
+	Verdict_Runtime_Error         Verdict = "RE"
+	Verdict_Compile_Error         Verdict = "CE"
+	Verdict_Output_Limit_Exceeded Verdict = "OLE"
+	Verdict_System_Error          Verdict = "SE"
+	// Skipped is given to a test case that did not run to the end: one of a
+	// group that stops early after another one failed, one left once a test
+	// case failed with stop_on_first_failure, or, in the test_finished events
+	// only, one stopped by a shutdown of cee, which another worker judges again
+	Verdict_Skipped Verdict = "SK"
+)
+
+// Usage is what a test case consumed. Times are in milliseconds and memory in
+// kilobytes. The CPU time and peak memory are sampled, and left out when no
+// sample was taken.
+type Usage struct {
+	WallTime   int64 `json:"wall_time_ms"`
+	CpuTime    int64 `json:"cpu_time_ms,omitempty"`
+	PeakMemory int64 `json:"peak_memory_kb,omitempty"`
+	ExitCode   int64 `json:"exit_code"`
 }
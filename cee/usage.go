@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Usage_Monitor follows the docker stats stream of a running container and
+// remembers its peak memory and the last CPU time it reported. Docker samples
+// about once a second and the cgroup of a container is gone once it exits, so
+// the CPU time and memory are lower bounds, and are left out of the usage of a
+// run too short to be sampled. They are only reported, the kernel enforces the
+// CPU time limit, see Cpu_Limit.Apply_Ulimit.
+type Usage_Monitor struct {
+	cli          *client.Client
+	container_id string
+	cancel       context.CancelFunc
+	done         chan struct{}
+	mutex        sync.Mutex
+	cpu_time     time.Duration
+	peak_memory  uint64
+	stopped      bool
+	usage        Usage
+	oom_killed   bool
+}
+
+func Start_Usage_Monitor(cli *client.Client, container_id string) *Usage_Monitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	monitor := &Usage_Monitor{
+		cli:          cli,
+		container_id: container_id,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+	go monitor.follow(ctx)
+	return monitor
+}
+
+func (monitor *Usage_Monitor) follow(ctx context.Context) {
+	defer close(monitor.done)
+	stats, err := monitor.cli.ContainerStats(ctx, monitor.container_id, true)
+	if err != nil {
+		return
+	}
+	defer stats.Body.Close()
+	decoder := json.NewDecoder(stats.Body)
+	for {
+		var sample types.StatsJSON
+		if err := decoder.Decode(&sample); err != nil {
+			return
+		}
+		monitor.record(sample)
+	}
+}
+
+func (monitor *Usage_Monitor) record(sample types.StatsJSON) {
+	// Docker sends an empty sample for a container that is not running
+	if sample.Read.IsZero() {
+		return
+	}
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	cpu_time := time.Duration(sample.CPUStats.CPUUsage.TotalUsage)
+	if cpu_time > monitor.cpu_time {
+		monitor.cpu_time = cpu_time
+	}
+	memory := sample.MemoryStats.Usage
+	if sample.MemoryStats.MaxUsage > memory {
+		memory = sample.MemoryStats.MaxUsage
+	}
+	if memory > monitor.peak_memory {
+		monitor.peak_memory = memory
+	}
+}
+
+// Stop ends the stats stream and returns the usage of the container. The wall
+// time and the exit code come from the container state, so the container must
+// not be removed yet. Calling it again returns the same usage.
+func (monitor *Usage_Monitor) Stop() Usage {
+	if !monitor.stopped {
+		monitor.stopped = true
+		monitor.usage = monitor.stop()
+	}
+	return monitor.usage
+}
+
+// OOM_Killed tells whether the kernel killed the container for going over its
+// memory limit, known once Stop was called.
+func (monitor *Usage_Monitor) OOM_Killed() bool {
+	return monitor.oom_killed
+}
+
+func (monitor *Usage_Monitor) stop() Usage {
+	monitor.cancel()
+	<-monitor.done
+	monitor.mutex.Lock()
+	usage := Usage{
+		CpuTime:    monitor.cpu_time.Milliseconds(),
+		PeakMemory: int64(monitor.peak_memory / 1024),
+	}
+	monitor.mutex.Unlock()
+	inspect, err := monitor.cli.ContainerInspect(context.Background(), monitor.container_id)
+	if err != nil || inspect.State == nil {
+		return usage
+	}
+	usage.ExitCode = int64(inspect.State.ExitCode)
+	monitor.oom_killed = inspect.State.OOMKilled
+	started_at, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	if err != nil {
+		return usage
+	}
+	finished_at, err := time.Parse(time.RFC3339Nano, inspect.State.FinishedAt)
+	if err != nil || finished_at.Before(started_at) {
+		// Still running, e.g. stopped because of the time limit
+		finished_at = time.Now()
+	}
+	usage.WallTime = finished_at.Sub(started_at).Milliseconds()
+	return usage
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	max_batch_size = 1000
+	batch_ttl      = 24 * time.Hour
+)
+
+func Batch_Key(batch_id string) string {
+	return "batch:" + batch_id
+}
+
+// Make_Submissions enqueues an array of submissions as one batch. Every
+// submission is validated first, so a batch is either enqueued whole or
+// rejected with the index of the first invalid one. The submissions, their
+// callbacks and the batch are saved with one Redis pipeline before anything
+// is published. When publishing fails the submissions left are marked as a
+// system error.
+func Make_Submissions(publisher *MQ_Publisher, language_queues map[string]string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		submissions := []*Submission{}
+		if err := c.BodyParser(&submissions); err != nil {
+			return Send_Error(c, fiber.StatusBadRequest, Error_Invalid_Body, err.Error())
+		}
+		if len(submissions) == 0 || len(submissions) > max_batch_size {
+			return Send_Validation_Errors(c, []Field_Error{{
+				Code:    Field_Out_Of_Range,
+				Field:   "submissions",
+				Message: fmt.Sprintf("a batch must have between 1 and %d submissions", max_batch_size),
+			}})
+		}
+		batch_errors := []Field_Error{}
+		for index, submission := range submissions {
+			field_errors, err := Validate_Submission(submission, language_queues)
+			if err != nil {
+				return Send_Internal_Error(c, err)
+			}
+			for _, field_error := range field_errors {
+				field_error.Field = fmt.Sprintf("submissions[%d].%s", index, field_error.Field)
+				batch_errors = append(batch_errors, field_error)
+			}
+		}
+		if len(batch_errors) > 0 {
+			return Send_Validation_Errors(c, batch_errors)
+		}
+
+		ctx := context.Background()
+		batch := Batch{BatchID: uuid.New().String()}
+		updated_submissions := make([]Submission, len(submissions))
+		submissions_json := make([][]byte, len(submissions))
+		pipe := redis_client.TxPipeline()
+		for index, submission := range submissions {
+			updated_submissions[index] = UpdateSubmission(submission)
+			submission_id := updated_submissions[index].SubmissionID
+			submission_json, err := json.Marshal(updated_submissions[index])
+			if err != nil {
+				return Send_Internal_Error(c, err)
+			}
+			submissions_json[index] = submission_json
+			batch.SubmissionIDs = append(batch.SubmissionIDs, submission_id)
+			pipe.Set(ctx, submission_id, submission_json, 0)
+			if submission.CallbackURL != "" {
+				callback_json, err := json.Marshal(Callback{URL: submission.CallbackURL, Secret: submission.CallbackSecret})
+				if err != nil {
+					return Send_Internal_Error(c, err)
+				}
+				pipe.Set(ctx, Callback_Key(submission_id), callback_json, callback_ttl)
+			}
+		}
+		batch_json, err := json.Marshal(batch)
+		if err != nil {
+			return Send_Internal_Error(c, err)
+		}
+		pipe.Set(ctx, Batch_Key(batch.BatchID), batch_json, batch_ttl)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return Send_Internal_Error(c, err)
+		}
+
+		for index, submission := range updated_submissions {
+			if err := publisher.Publish(Queue_For_Language(language_queues, submission.Language), submissions_json[index]); err != nil {
+				// The earlier submissions are already queued and will run
+				log.Printf("Failed to publish submission %d of batch %s: %v", index, batch.BatchID, err)
+				Mark_Not_Queued(updated_submissions[index:], err)
+				body := Error_Body(Error_Internal, err.Error())
+				body["batch_id"] = batch.BatchID
+				body["submission_ids"] = batch.SubmissionIDs[:index]
+				return c.Status(fiber.StatusInternalServerError).JSON(body)
+			}
+			Publish_Event(Submission_Event{Type: Event_Queued, SubmissionID: submission.SubmissionID})
+		}
+		return c.JSON(batch)
+	}
+}
+
+// Mark_Not_Queued sets the status of the submissions of a batch that failed to
+// be published to "system error", so the batch does not wait for them.
+func Mark_Not_Queued(submissions []Submission, publish_err error) {
+	ctx := context.Background()
+	pipe := redis_client.TxPipeline()
+	for _, submission := range submissions {
+		submission.Status = "system error"
+		submission.Verdict = Verdict_System_Error
+		submission.Error = "failed to queue the submission: " + publish_err.Error()
+		submission_json, err := json.Marshal(submission)
+		if err != nil {
+			log.Printf("Failed to encode submission %s: %v", submission.SubmissionID, err)
+			continue
+		}
+		pipe.Set(ctx, submission.SubmissionID, submission_json, 0)
+		pipe.Del(ctx, Callback_Key(submission.SubmissionID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to mark %d submissions that were not queued: %v", len(submissions), err)
+		return
+	}
+	for _, submission := range submissions {
+		Publish_Event(Submission_Event{Type: Event_Judged, SubmissionID: submission.SubmissionID, Verdict: Verdict_System_Error})
+	}
+}
+
+// Get_Batch returns the aggregated progress and verdicts of a batch.
+func Get_Batch(c *fiber.Ctx) error {
+	batch_id := c.Params("batch_id")
+	ctx := context.Background()
+	batch_json, err := Get_Data_From_Redis(Batch_Key(batch_id))
+	if err == redis.Nil {
+		return Send_Error(c, fiber.StatusNotFound, Error_Not_Found, "batch not found")
+	}
+	if err != nil {
+		return Send_Internal_Error(c, err)
+	}
+	var batch Batch
+	if err := json.Unmarshal([]byte(batch_json), &batch); err != nil {
+		return Send_Internal_Error(c, err)
+	}
+	values, err := redis_client.MGet(ctx, batch.SubmissionIDs...).Result()
+	if err != nil {
+		return Send_Internal_Error(c, err)
+	}
+	progress := Batch_Progress{
+		BatchID:     batch.BatchID,
+		Total:       len(batch.SubmissionIDs),
+		Verdicts:    map[Verdict]int{},
+		Submissions: make([]Batch_Item, len(batch.SubmissionIDs)),
+	}
+	for index, value := range values {
+		item := Batch_Item{SubmissionID: batch.SubmissionIDs[index]}
+		data, ok := value.(string)
+		var submission Submission
+		if !ok || json.Unmarshal([]byte(data), &submission) != nil {
+			progress.Missing++
+			progress.Submissions[index] = item
+			continue
+		}
+		item.Status = submission.Status
+		item.Verdict = submission.Verdict
+		item.Verdicts = submission.Test_Verdicts()
+		item.Score = submission.Score
+		item.Error = submission.Error
+		if submission.Is_Finished() {
+			progress.Done++
+			progress.Verdicts[submission.Verdict]++
+		} else {
+			progress.Pending++
+		}
+		progress.Submissions[index] = item
+	}
+	return c.JSON(progress)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Types of the progress events of a submission, published by the router
+// (queued) and by cee (the others).
+const (
+	Event_Queued        = "queued"
+	Event_Compiling     = "compiling"
+	Event_Running       = "running"
+	Event_Test_Finished = "test_finished"
+	Event_Judged        = "judged"
+)
+
+const (
+	events_heartbeat_interval = 15 * time.Second
+	events_stream_timeout     = 5 * time.Minute
+	default_wait_timeout      = 20 * time.Second
+	max_wait_timeout          = time.Minute
+)
+
+type Submission_Event struct {
+	Type         string  `json:"type"`
+	SubmissionID string  `json:"submission_id"`
+	Index        *int    `json:"index,omitempty"`
+	Verdict      Verdict `json:"verdict,omitempty"`
+}
+
+func Submission_Events_Channel(submission_id string) string {
+	return "submission:" + submission_id + ":events"
+}
+
+// Publish_Event publishes a progress event, a failure is only logged.
+func Publish_Event(event Submission_Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event of submission %s: %v", event.Type, event.SubmissionID, err)
+		return
+	}
+	err = redis_client.Publish(context.Background(), Submission_Events_Channel(event.SubmissionID), data).Err()
+	if err != nil {
+		log.Printf("Failed to publish %s event of submission %s: %v", event.Type, event.SubmissionID, err)
+	}
+}
+
+// Stream_Submission_Events streams the progress of a submission as
+// Server-Sent Events. The first event is the current state, read after
+// subscribing so nothing published in between is lost, and the stream ends
+// with the judged event.
+func Stream_Submission_Events(c *fiber.Ctx) error {
+	submission_id := c.Params("submission_id")
+	pubsub, err := Subscribe_Submission_Events(submission_id)
+	if err != nil {
+		return Send_Internal_Error(c, err)
+	}
+	submission_json, err := Get_Data_From_Redis(submission_id)
+	if err != nil {
+		pubsub.Close()
+		if err == redis.Nil {
+			return Send_Error(c, fiber.StatusNotFound, Error_Not_Found, "submission not found")
+		}
+		return Send_Internal_Error(c, err)
+	}
+	var submission Submission
+	if err := json.Unmarshal([]byte(submission_json), &submission); err != nil {
+		pubsub.Close()
+		return Send_Internal_Error(c, err)
+	}
+	current := Submission_Event{Type: Event_Queued, SubmissionID: submission_id}
+	if submission.Is_Finished() {
+		current = Submission_Event{Type: Event_Judged, SubmissionID: submission_id, Verdict: submission.Verdict}
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer pubsub.Close()
+		data, _ := json.Marshal(current)
+		if Write_Event(w, current.Type, data) != nil || current.Type == Event_Judged {
+			return
+		}
+		messages := pubsub.Channel()
+		heartbeat := time.NewTicker(events_heartbeat_interval)
+		defer heartbeat.Stop()
+		timeout := time.NewTimer(events_stream_timeout)
+		defer timeout.Stop()
+		for {
+			select {
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event Submission_Event
+				if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+					log.Printf("Failed to parse event of submission %s: %v", submission_id, err)
+					continue
+				}
+				if Write_Event(w, event.Type, []byte(message.Payload)) != nil || event.Type == Event_Judged {
+					return
+				}
+			case <-heartbeat.C:
+				// A comment line, it fails once the client is gone
+				fmt.Fprint(w, ": keep-alive\n\n")
+				if w.Flush() != nil {
+					return
+				}
+			case <-timeout.C:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func Write_Event(w *bufio.Writer, event_type string, data []byte) error {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event_type, data)
+	return w.Flush()
+}
+
+// Subscribe_Submission_Events subscribes to the events of a submission and
+// returns once the subscription is active.
+func Subscribe_Submission_Events(submission_id string) (*redis.PubSub, error) {
+	ctx := context.Background()
+	pubsub := redis_client.Subscribe(ctx, Submission_Events_Channel(submission_id))
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+	return pubsub, nil
+}
+
+// Parse_Wait reads the wait and timeout query parameters of make_submission,
+// e.g. ?wait=true&timeout=20s.
+func Parse_Wait(c *fiber.Ctx) (bool, time.Duration, error) {
+	if !c.QueryBool("wait") {
+		return false, 0, nil
+	}
+	timeout := default_wait_timeout
+	if value := c.Query("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return false, 0, fmt.Errorf("invalid timeout %q, expected a duration such as 20s", value)
+		}
+		if parsed > max_wait_timeout {
+			return false, 0, fmt.Errorf("timeout must be at most %v", max_wait_timeout)
+		}
+		timeout = parsed
+	}
+	return true, timeout, nil
+}
+
+// Wait_For_Judged blocks until the judged event of the subscribed submission
+// or the timeout, and tells whether the submission was judged.
+func Wait_For_Judged(pubsub *redis.PubSub, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	messages := pubsub.Channel()
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return false
+			}
+			var event Submission_Event
+			if json.Unmarshal([]byte(message.Payload), &event) == nil && event.Type == Event_Judged {
+				return true
+			}
+		case <-timer.C:
+			return false
+		}
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -22,7 +26,7 @@ var rabbitmq_username string = strings.TrimSpace(os.Getenv("RABBMITMQ_USERNAME")
 var redis_password string = strings.TrimSpace(os.Getenv("REDIS_PASSWORD"))
 var redis_sentinel_address string = strings.TrimSpace(os.Getenv("REDIS_SENTINELS"))
 
-// TODO: remember to change this to the correct queue name
+// Default queue, for the languages LANGUAGE_QUEUES does not list
 var queue_name string = strings.TrimSpace(os.Getenv("CEE_INTERPRETER_QUEUE_NAME"))
 
 var redis_client = redis.NewFailoverClient(&redis.FailoverOptions{
@@ -56,6 +60,135 @@ func Get_Data_From_Redis(key string) (string, error) {
 	return val, nil
 }
 
+// Key prefix under which cee workers advertise their languages.
+const language_key_prefix = "language:"
+
+// Get_Languages returns the languages currently advertised by cee workers,
+// sorted by name.
+func Get_Languages() ([]Language_Info, error) {
+	ctx := context.Background()
+	keys := []string{}
+	iter := redis_client.Scan(ctx, 0, language_key_prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	languages := []Language_Info{}
+	if len(keys) == 0 {
+		return languages, nil
+	}
+	values, err := redis_client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		// The key may have expired between the scan and the read
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var language Language_Info
+		if err := json.Unmarshal([]byte(data), &language); err != nil {
+			log.Printf("Failed to parse advertised language: %v", err)
+			continue
+		}
+		languages = append(languages, language)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		return languages[i].Name < languages[j].Name
+	})
+	return languages, nil
+}
+
+func Is_Supported_Language(name string) (bool, error) {
+	if name == "" {
+		return false, nil
+	}
+	count, err := redis_client.Exists(context.Background(), language_key_prefix+name).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Callbacks are kept a while longer than cee keeps the judged submission.
+const callback_ttl = time.Hour
+
+const callback_resolve_timeout = 2 * time.Second
+
+// With CALLBACK_ALLOWED_HOSTS, e.g. "example.com,hooks.example.org", callbacks
+// only go to these hosts and their subdomains. Without it any host is accepted
+// unless it resolves to a private, loopback or link-local address, so callbacks
+// cannot reach the services of the cluster.
+var callback_allowed_hosts = Parse_Allowed_Hosts(os.Getenv("CALLBACK_ALLOWED_HOSTS"))
+
+func Parse_Allowed_Hosts(value string) []string {
+	hosts := []string{}
+	for _, host := range strings.Split(value, ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+func Is_Allowed_Host(host string, allowed_hosts []string) bool {
+	for _, allowed := range allowed_hosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func Is_Internal_IP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+func Validate_Callback_URL(callback_url string) error {
+	parsed, err := url.Parse(callback_url)
+	if err != nil {
+		return err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("callback_url must be an absolute http or https URL")
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if len(callback_allowed_hosts) > 0 {
+		if !Is_Allowed_Host(host, callback_allowed_hosts) {
+			return fmt.Errorf("callback_url host %s is not allowed", host)
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), callback_resolve_timeout)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("callback_url host %s cannot be resolved", host)
+	}
+	for _, address := range addresses {
+		if Is_Internal_IP(address.IP) {
+			return fmt.Errorf("callback_url host %s resolves to the internal address %s", host, address.IP)
+		}
+	}
+	return nil
+}
+
+func Callback_Key(submission_id string) string {
+	return "callback:" + submission_id
+}
+
+func Save_Callback(submission_id string, callback Callback) error {
+	callback_json, err := json.Marshal(callback)
+	if err != nil {
+		return err
+	}
+	return redis_client.Set(context.Background(), Callback_Key(submission_id), callback_json, callback_ttl).Err()
+}
+
 func Initiate_Redis_Client() (*redis.Client, error) {
 	context := context.Background()
 	client := redis.NewClient(&redis.Options{
@@ -94,6 +227,36 @@ func Declare_MQ_Queue(ch *amqp.Channel, queue_name string) error {
 	return nil
 }
 
+// Parse_Language_Queues parses "c=cee-compiler-queue,cpp=cee-compiler-queue"
+// into the queue of every language. cee must be given the same mapping so its
+// workers consume the queues of the languages they host.
+func Parse_Language_Queues(value string) (map[string]string, error) {
+	queues := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		language, queue, found := strings.Cut(entry, "=")
+		language = strings.TrimSpace(language)
+		queue = strings.TrimSpace(queue)
+		if !found || language == "" || queue == "" {
+			return nil, fmt.Errorf("invalid language queue %q, expected <language>=<queue>", entry)
+		}
+		queues[language] = queue
+	}
+	return queues, nil
+}
+
+// Queue_For_Language returns the queue a submission in language is published
+// to.
+func Queue_For_Language(language_queues map[string]string, language string) string {
+	if queue, ok := language_queues[language]; ok {
+		return queue
+	}
+	return queue_name
+}
+
 func Initiate_MQ_Channel(conn *amqp.Connection) (*amqp.Channel, error) {
 	ch, err := conn.Channel()
 	if err != nil {
@@ -119,61 +282,115 @@ func Publish(ch *amqp.Channel, queue_name string, data []byte) error {
 }
 
 func main() {
-	app := fiber.New()
-	mq_conn, err := Initiate_MQ_Client()
+	app := fiber.New(fiber.Config{
+		BodyLimit:    max_body_bytes,
+		ErrorHandler: Error_Handler,
+	})
+	language_queues, err := Parse_Language_Queues(os.Getenv("LANGUAGE_QUEUES"))
 	if err != nil {
-		log.Fatal("Failed to connect to RabbitMQ", err)
+		log.Fatal("Failed to parse LANGUAGE_QUEUES", err)
 	}
-	defer mq_conn.Close()
-	ch, err := Initiate_MQ_Channel(mq_conn)
-	if err != nil {
-		log.Fatal("Failed to open a channel", err)
+	queues := []string{queue_name}
+	for _, queue := range language_queues {
+		queues = append(queues, queue)
 	}
-	err = Declare_MQ_Queue(ch, queue_name)
+	publisher, err := New_MQ_Publisher(queues)
 	if err != nil {
-		log.Fatal("Failed to declare a queue", err)
+		log.Fatal(err)
 	}
+	defer publisher.Close()
 	app.Post("/make_submission", func(c *fiber.Ctx) error {
 		submission := new(Submission)
 
 		if err := c.BodyParser(submission); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": err.Error(),
-			})
+			return Send_Error(c, fiber.StatusBadRequest, Error_Invalid_Body, err.Error())
+		}
+		// Not the whole submission, it may hold a callback secret
+		log.Printf("Submission in %s with %d test cases", submission.Language, submission.Test_Count())
+		field_errors, err := Validate_Submission(submission, language_queues)
+		if err != nil {
+			return Send_Internal_Error(c, err)
+		}
+		wait, wait_timeout, err := Parse_Wait(c)
+		if err != nil {
+			field_errors = append(field_errors, Field_Error{Code: Field_Invalid_Value, Field: "timeout", Message: err.Error()})
+		}
+		if len(field_errors) > 0 {
+			return Send_Validation_Errors(c, field_errors)
 		}
-		log.Printf("Submission: %v", submission)
 
 		updated_submission := UpdateSubmission(submission)
+		var pubsub *redis.PubSub
+		if wait {
+			// Subscribed before publishing so the judged event cannot be missed
+			pubsub, err = Subscribe_Submission_Events(updated_submission.SubmissionID)
+			if err != nil {
+				return Send_Internal_Error(c, err)
+			}
+			defer pubsub.Close()
+		}
 		updated_submission_json, err := json.Marshal(updated_submission)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": err.Error(),
-			})
+			return Send_Internal_Error(c, err)
 		}
 		if err := Set_Data_To_Redis(updated_submission.SubmissionID, string(updated_submission_json)); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": err.Error(),
-			})
+			return Send_Internal_Error(c, err)
+		}
+		// Saved before publishing so cee finds it once the submission is judged
+		if submission.CallbackURL != "" {
+			callback := Callback{URL: submission.CallbackURL, Secret: submission.CallbackSecret}
+			if err := Save_Callback(updated_submission.SubmissionID, callback); err != nil {
+				return Send_Internal_Error(c, err)
+			}
 		}
-		if err := Publish(ch, queue_name, updated_submission_json); err != nil {
-			return c.Status(500).JSON(fiber.Map{
-				"error": err.Error(),
-			})
+		if err := publisher.Publish(Queue_For_Language(language_queues, updated_submission.Language), updated_submission_json); err != nil {
+			return Send_Internal_Error(c, err)
+		}
+		Publish_Event(Submission_Event{Type: Event_Queued, SubmissionID: updated_submission.SubmissionID})
+		if wait {
+			if !Wait_For_Judged(pubsub, wait_timeout) {
+				return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+					"submission_id": updated_submission.SubmissionID,
+					"status":        updated_submission.Status,
+				})
+			}
+			judged_submission, err := Get_Data_From_Redis(updated_submission.SubmissionID)
+			if err != nil {
+				return Send_Internal_Error(c, err)
+			}
+			c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			return c.SendString(judged_submission)
 		}
 		return c.SendString(updated_submission.SubmissionID)
 	})
 
+	app.Post("/make_submissions", Make_Submissions(publisher, language_queues))
+
+	app.Get("/batches/:batch_id", Get_Batch)
+
 	app.Get("/retrieve_submission/:submission_id", func(c *fiber.Ctx) error {
 		submission_id := c.Params("submission_id")
 		submission, err := Get_Data_From_Redis(submission_id)
+		if err == redis.Nil {
+			return Send_Error(c, fiber.StatusNotFound, Error_Not_Found, "submission not found")
+		}
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": err.Error(),
-			})
+			return Send_Internal_Error(c, err)
 		}
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
 		return c.SendString(submission)
 	})
 
+	app.Get("/submission_events/:submission_id", Stream_Submission_Events)
+
+	app.Get("/languages", func(c *fiber.Ctx) error {
+		languages, err := Get_Languages()
+		if err != nil {
+			return Send_Internal_Error(c, err)
+		}
+		return c.JSON(languages)
+	})
+
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("Hello, World!")
 	})
@@ -182,22 +399,44 @@ func main() {
 }
 
 func UpdateSubmission(submission *Submission) Submission {
+	if len(submission.Tests) > 0 {
+		tests := make([]Test, len(submission.Tests))
+		for index, test := range submission.Tests {
+			tests[index] = test
+			tests[index].Result = nil
+		}
+		return Submission{
+			SubmissionID:       uuid.New().String(),
+			Status:             "pending",
+			Language:           submission.Language,
+			Code:               submission.Code,
+			Interactor:         submission.Interactor,
+			Tests:              tests,
+			Groups:             submission.Groups,
+			StopOnFirstFailure: submission.StopOnFirstFailure,
+		}
+	}
 	var memory_limits []int
 	var time_limits []int
+	var comparators []Comparator
 	for _, configuration := range submission.Configuration {
 		memory_limits = append(memory_limits, configuration.MemoryLimit)
 		time_limits = append(time_limits, configuration.TimeLimit)
+		comparators = append(comparators, configuration.Comparator)
 	}
 	newSubmission := Submission{
-		SubmissionID: uuid.New().String(),
-		Status:       "pending",
-		Language:     submission.Language,
-		Stdin:        submission.Input,
-		TestCases:    submission.TestCases,
-		Code:         submission.Code,
-		Replace:      submission.Replace,
-		MemoryLimit:  memory_limits,
-		TimeLimit:    time_limits,
+		SubmissionID:       uuid.New().String(),
+		Status:             "pending",
+		Language:           submission.Language,
+		Stdin:              submission.Input,
+		TestCases:          submission.TestCases,
+		Code:               submission.Code,
+		Replace:            submission.Replace,
+		MemoryLimit:        memory_limits,
+		TimeLimit:          time_limits,
+		Comparators:        comparators,
+		Interactor:         submission.Interactor,
+		StopOnFirstFailure: submission.StopOnFirstFailure,
 	}
 	return newSubmission
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	mq_first_backoff = time.Second
+	mq_max_backoff   = 30 * time.Second
+)
+
+// MQ_Publisher keeps a channel to RabbitMQ open for the whole life of the
+// router. When the connection is lost, e.g. the broker restarted, it
+// reconnects with backoff and declares the queues again. Publishing while it
+// is disconnected fails instead of blocking the request.
+type MQ_Publisher struct {
+	queues  []string
+	mutex   sync.Mutex
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	closing bool
+}
+
+// New_MQ_Publisher connects and declares the queues. The first connection has
+// to succeed, the later ones are retried in the background.
+func New_MQ_Publisher(queues []string) (*MQ_Publisher, error) {
+	publisher := &MQ_Publisher{queues: queues}
+	if err := publisher.connect(); err != nil {
+		return nil, err
+	}
+	go publisher.reconnect_on_close()
+	return publisher, nil
+}
+
+func (publisher *MQ_Publisher) connect() error {
+	conn, err := Initiate_MQ_Client()
+	if err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+	}
+	ch, err := Initiate_MQ_Channel(conn)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to open a channel: %v", err)
+	}
+	for _, queue := range publisher.queues {
+		if err := Declare_MQ_Queue(ch, queue); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to declare queue %s: %v", queue, err)
+		}
+	}
+	publisher.mutex.Lock()
+	publisher.conn = conn
+	publisher.ch = ch
+	publisher.mutex.Unlock()
+	return nil
+}
+
+// reconnect_on_close waits for the connection or the channel to close, a
+// channel error closes the channel alone, and connects again.
+func (publisher *MQ_Publisher) reconnect_on_close() {
+	for {
+		publisher.mutex.Lock()
+		// Buffered, amqp blocks until the close is delivered
+		conn_closed := publisher.conn.NotifyClose(make(chan *amqp.Error, 1))
+		ch_closed := publisher.ch.NotifyClose(make(chan *amqp.Error, 1))
+		publisher.mutex.Unlock()
+		var err *amqp.Error
+		select {
+		case err = <-conn_closed:
+		case err = <-ch_closed:
+		}
+		publisher.mutex.Lock()
+		if publisher.closing {
+			publisher.mutex.Unlock()
+			return
+		}
+		publisher.conn.Close()
+		publisher.conn = nil
+		publisher.ch = nil
+		publisher.mutex.Unlock()
+		log.Printf("AMQP connection closed: %v", err)
+		backoff := mq_first_backoff
+		for {
+			err := publisher.connect()
+			if err == nil {
+				log.Printf("Reconnected to RabbitMQ")
+				break
+			}
+			log.Printf("Failed to reconnect to RabbitMQ, retrying in %v: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > mq_max_backoff {
+				backoff = mq_max_backoff
+			}
+		}
+	}
+}
+
+func (publisher *MQ_Publisher) Publish(queue_name string, data []byte) error {
+	publisher.mutex.Lock()
+	ch := publisher.ch
+	publisher.mutex.Unlock()
+	if ch == nil || ch.IsClosed() {
+		return fmt.Errorf("not connected to RabbitMQ, reconnecting")
+	}
+	return Publish(ch, queue_name, data)
+}
+
+func (publisher *MQ_Publisher) Close() {
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
+	publisher.closing = true
+	if publisher.conn != nil {
+		publisher.conn.Close()
+	}
+}
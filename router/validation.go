@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error codes of the API. Every error response is
+// {"error": <message>, "code": <code>, "errors": [<field errors>]}.
+const (
+	Error_Invalid_Body      = "invalid_body"
+	Error_Validation_Failed = "validation_failed"
+	Error_Not_Found         = "not_found"
+	Error_Body_Too_Large    = "body_too_large"
+	Error_Internal          = "internal_error"
+)
+
+// Codes of the field errors of a validation_failed error.
+const (
+	Field_Required             = "required"
+	Field_Length_Mismatch      = "length_mismatch"
+	Field_Invalid_Base64       = "invalid_base64"
+	Field_Out_Of_Range         = "out_of_range"
+	Field_Too_Large            = "too_large"
+	Field_Unsupported_Language = "unsupported_language"
+	Field_Invalid_Value        = "invalid_value"
+)
+
+const (
+	max_code_bytes       = 64 * 1024
+	max_test_cases       = 100
+	max_test_case_bytes  = 8 * 1024 * 1024
+	min_memory_limit_mb  = 6
+	max_memory_limit_mb  = 1024
+	max_time_limit       = 30
+	max_cpus             = 4
+	max_interactor_query = 1000000
+	// Largest request body, base64 makes code and test data a third larger.
+	// It leaves room for a few test cases of max_test_case_bytes.
+	max_body_bytes = 64 * 1024 * 1024
+)
+
+// Field_Error tells what is wrong with one field of the request, Field is its
+// path in the JSON body, e.g. configuration[2].time_limit.
+type Field_Error struct {
+	Code    string `json:"code"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func Send_Error(c *fiber.Ctx, status int, code string, message string, field_errors ...Field_Error) error {
+	return c.Status(status).JSON(Error_Body(code, message, field_errors...))
+}
+
+// Error_Body is the body of an error response, for the ones that add fields
+// to it.
+func Error_Body(code string, message string, field_errors ...Field_Error) fiber.Map {
+	body := fiber.Map{
+		"error": message,
+		"code":  code,
+	}
+	if len(field_errors) > 0 {
+		body["errors"] = field_errors
+	}
+	return body
+}
+
+// Error_Handler sends the errors fiber returns itself, such as a body over
+// max_body_bytes, in the shape of the other errors.
+func Error_Handler(c *fiber.Ctx, err error) error {
+	var fiber_error *fiber.Error
+	if !errors.As(err, &fiber_error) {
+		return Send_Internal_Error(c, err)
+	}
+	switch {
+	case fiber_error.Code == fiber.StatusRequestEntityTooLarge:
+		return Send_Error(c, fiber_error.Code, Error_Body_Too_Large, fmt.Sprintf("request body must be at most %d bytes", max_body_bytes))
+	case fiber_error.Code == fiber.StatusNotFound:
+		return Send_Error(c, fiber_error.Code, Error_Not_Found, fiber_error.Message)
+	case fiber_error.Code < fiber.StatusInternalServerError:
+		return Send_Error(c, fiber_error.Code, Error_Invalid_Body, fiber_error.Message)
+	default:
+		return Send_Error(c, fiber_error.Code, Error_Internal, fiber_error.Message)
+	}
+}
+
+func Send_Internal_Error(c *fiber.Ctx, err error) error {
+	return Send_Error(c, fiber.StatusInternalServerError, Error_Internal, err.Error())
+}
+
+func Send_Validation_Errors(c *fiber.Ctx, field_errors []Field_Error) error {
+	return Send_Error(c, fiber.StatusBadRequest, Error_Validation_Failed, field_errors[0].Field+": "+field_errors[0].Message, field_errors...)
+}
+
+// Submission_Validator collects every error of a request instead of stopping
+// at the first one.
+type Submission_Validator struct {
+	Errors []Field_Error
+	// Language_Queues and Queue, the queue of the submission, tell the
+	// languages hosted by the workers judging it
+	Language_Queues map[string]string
+	Queue           string
+}
+
+func (validator *Submission_Validator) Add(code string, field string, format string, args ...interface{}) {
+	validator.Errors = append(validator.Errors, Field_Error{
+		Code:    code,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Base64 checks that value is base64 and returns its decoded size.
+func (validator *Submission_Validator) Base64(field string, value string) int {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		validator.Add(Field_Invalid_Base64, field, "must be base64 encoded")
+		return 0
+	}
+	return len(decoded)
+}
+
+// supported_language tells whether a language is published to Redis, tests
+// replace it.
+var supported_language = Is_Supported_Language
+
+func (validator *Submission_Validator) Language(field string, language string) error {
+	if language == "" {
+		validator.Add(Field_Required, field, "is required")
+		return nil
+	}
+	supported, err := supported_language(language)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		validator.Add(Field_Unsupported_Language, field, "language %q is not supported", language)
+	}
+	return nil
+}
+
+// Helper_Language checks the language of a checker or an interactor. It runs
+// on the worker judging the submission, so its language has to be published to
+// the queue of the submission.
+func (validator *Submission_Validator) Helper_Language(field string, language string) error {
+	errors := len(validator.Errors)
+	if err := validator.Language(field, language); err != nil {
+		return err
+	}
+	if len(validator.Errors) == errors && Queue_For_Language(validator.Language_Queues, language) != validator.Queue {
+		validator.Add(Field_Unsupported_Language, field, "language %q is not hosted by the workers of queue %s", language, validator.Queue)
+	}
+	return nil
+}
+
+// Validate_Submission checks a make_submission request. The field errors are
+// the reasons to reject it, the error is a failure to check it, e.g. Redis
+// being unreachable.
+func Validate_Submission(submission *Submission, language_queues map[string]string) ([]Field_Error, error) {
+	validator := &Submission_Validator{
+		Language_Queues: language_queues,
+		Queue:           Queue_For_Language(language_queues, submission.Language),
+	}
+	if err := validator.Language("language", submission.Language); err != nil {
+		return nil, err
+	}
+	if submission.Code == "" {
+		validator.Add(Field_Required, "code", "is required")
+	} else if size := validator.Base64("code", submission.Code); size > max_code_bytes {
+		validator.Add(Field_Too_Large, "code", "must be at most %d bytes", max_code_bytes)
+	}
+
+	var err error
+	if len(submission.Tests) > 0 {
+		err = validator.Tests(submission)
+	} else {
+		err = validator.Legacy_Tests(submission)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if submission.Interactor != nil {
+		if err := validator.Helper_Language("interactor.language", submission.Interactor.Language); err != nil {
+			return nil, err
+		}
+		if submission.Interactor.Code == "" {
+			validator.Add(Field_Required, "interactor.code", "is required")
+		} else if size := validator.Base64("interactor.code", submission.Interactor.Code); size > max_code_bytes {
+			validator.Add(Field_Too_Large, "interactor.code", "must be at most %d bytes", max_code_bytes)
+		}
+		if submission.Interactor.QueryLimit < 0 || submission.Interactor.QueryLimit > max_interactor_query {
+			validator.Add(Field_Out_Of_Range, "interactor.query_limit", "must be between 0 and %d", max_interactor_query)
+		}
+	}
+
+	if submission.CallbackURL != "" {
+		if err := Validate_Callback_URL(submission.CallbackURL); err != nil {
+			validator.Add(Field_Invalid_Value, "callback_url", "%v", err)
+		}
+	}
+	return validator.Errors, nil
+}
+
+// Tests checks the test cases of a v2 submission.
+func (validator *Submission_Validator) Tests(submission *Submission) error {
+	if len(submission.Input) > 0 || len(submission.TestCases) > 0 || len(submission.Replace) > 0 || len(submission.Configuration) > 0 {
+		validator.Add(Field_Invalid_Value, "tests", "cannot be combined with input, test_cases, replace or configuration")
+	}
+	if len(submission.Tests) > max_test_cases {
+		validator.Add(Field_Too_Large, "tests", "at most %d test cases are allowed", max_test_cases)
+	}
+	for index, test := range submission.Tests {
+		field := fmt.Sprintf("tests[%d]", index)
+		validator.Test_Data(field+".stdin", test.Stdin)
+		validator.Test_Data(field+".expected", test.Expected)
+		for replace_index, replace := range test.Replace {
+			replace_field := fmt.Sprintf("%s.replace[%d]", field, replace_index)
+			validator.Base64(replace_field+".from", replace.From)
+			validator.Base64(replace_field+".to", replace.To)
+		}
+		validator.Limits(field+".limits", test.Limits.TimeLimit, test.Limits.MemoryLimit)
+		validator.Cpu_Limits(field+".limits", test.Limits)
+		if err := validator.Comparator(field+".comparator", test.Comparator); err != nil {
+			return err
+		}
+		if test.Points < 0 {
+			validator.Add(Field_Out_Of_Range, field+".points", "must not be negative")
+		}
+	}
+	validator.Groups(submission.Groups)
+	return nil
+}
+
+// Groups checks the scoring of the groups. A group used by test cases without
+// being declared is all_or_nothing.
+func (validator *Submission_Validator) Groups(groups []Test_Group) {
+	seen := map[string]bool{}
+	for index, group := range groups {
+		field := fmt.Sprintf("groups[%d]", index)
+		if group.ID == "" {
+			validator.Add(Field_Required, field+".id", "is required")
+		} else if seen[group.ID] {
+			validator.Add(Field_Invalid_Value, field+".id", "group %q is declared twice", group.ID)
+		}
+		seen[group.ID] = true
+		if group.Scoring != "" && group.Scoring != Scoring_All_Or_Nothing && group.Scoring != Scoring_Proportional {
+			validator.Add(Field_Invalid_Value, field+".scoring", "must be %s or %s", Scoring_All_Or_Nothing, Scoring_Proportional)
+		}
+		if group.Points < 0 {
+			validator.Add(Field_Out_Of_Range, field+".points", "must not be negative")
+		}
+	}
+}
+
+// Legacy_Tests checks the parallel arrays of a submission in the original
+// format, which must all have one item per test case.
+func (validator *Submission_Validator) Legacy_Tests(submission *Submission) error {
+	if len(submission.Groups) > 0 {
+		validator.Add(Field_Invalid_Value, "groups", "groups need the tests format")
+	}
+	test_count := len(submission.Input)
+	if test_count == 0 {
+		validator.Add(Field_Required, "input", "at least one test case is required")
+	}
+	if test_count > max_test_cases {
+		validator.Add(Field_Too_Large, "input", "at most %d test cases are allowed", max_test_cases)
+	}
+	for index, input := range submission.Input {
+		validator.Test_Data(fmt.Sprintf("input[%d]", index), input)
+	}
+	if len(submission.TestCases) != test_count {
+		validator.Add(Field_Length_Mismatch, "test_cases", "has %d items, input has %d", len(submission.TestCases), test_count)
+	}
+	for index, expected := range submission.TestCases {
+		validator.Test_Data(fmt.Sprintf("test_cases[%d]", index), expected)
+	}
+	if len(submission.Replace) != 0 && len(submission.Replace) != test_count {
+		validator.Add(Field_Length_Mismatch, "replace", "has %d items, input has %d", len(submission.Replace), test_count)
+	}
+	for index, replaces := range submission.Replace {
+		for replace_index, replace := range replaces {
+			field := fmt.Sprintf("replace[%d][%d]", index, replace_index)
+			validator.Base64(field+".from", replace.From)
+			validator.Base64(field+".to", replace.To)
+		}
+	}
+	if len(submission.Configuration) != test_count {
+		validator.Add(Field_Length_Mismatch, "configuration", "has %d items, input has %d", len(submission.Configuration), test_count)
+	}
+	for index, configuration := range submission.Configuration {
+		field := fmt.Sprintf("configuration[%d]", index)
+		validator.Limits(field, configuration.TimeLimit, configuration.MemoryLimit)
+		if err := validator.Comparator(field+".comparator", configuration.Comparator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Test_Data checks the stdin or expected output of a test case.
+func (validator *Submission_Validator) Test_Data(field string, value string) {
+	if size := validator.Base64(field, value); size > max_test_case_bytes {
+		validator.Add(Field_Too_Large, field, "must be at most %d bytes", max_test_case_bytes)
+	}
+}
+
+// Limits checks the limits of a test case, 0 uses the default of the language.
+func (validator *Submission_Validator) Limits(field string, time_limit int, memory_limit int) {
+	if memory_limit != 0 && (memory_limit < min_memory_limit_mb || memory_limit > max_memory_limit_mb) {
+		validator.Add(Field_Out_Of_Range, field+".memory_limit", "must be 0 or between %d and %d MB", min_memory_limit_mb, max_memory_limit_mb)
+	}
+	if time_limit < 0 || time_limit > max_time_limit {
+		validator.Add(Field_Out_Of_Range, field+".time_limit", "must be 0 or between 1 and %d seconds", max_time_limit)
+	}
+}
+
+// Cpu_Limits checks the CPU limits of a v2 test case, 0 uses the default of
+// the language.
+func (validator *Submission_Validator) Cpu_Limits(field string, limits Test_Limits) {
+	if limits.CpuTimeLimit < 0 || limits.CpuTimeLimit > max_time_limit {
+		validator.Add(Field_Out_Of_Range, field+".cpu_time_limit", "must be 0 or between 1 and %d seconds", max_time_limit)
+	}
+	if limits.Cpus < 0 || limits.Cpus > max_cpus {
+		validator.Add(Field_Out_Of_Range, field+".cpus", "must be 0 or at most %d", max_cpus)
+	}
+}
+
+func (validator *Submission_Validator) Comparator(field string, comparator Comparator) error {
+	switch comparator.Type {
+	case "", "exact", "token", "case_insensitive", "line_set":
+	case "float":
+		if comparator.AbsoluteEpsilon < 0 {
+			validator.Add(Field_Out_Of_Range, field+".absolute_epsilon", "must not be negative")
+		}
+		if comparator.RelativeEpsilon < 0 {
+			validator.Add(Field_Out_Of_Range, field+".relative_epsilon", "must not be negative")
+		}
+	case "program":
+		if err := validator.Helper_Language(field+".language", comparator.Language); err != nil {
+			return err
+		}
+		if comparator.Code == "" {
+			validator.Add(Field_Required, field+".code", "is required")
+		} else if size := validator.Base64(field+".code", comparator.Code); size > max_code_bytes {
+			validator.Add(Field_Too_Large, field+".code", "must be at most %d bytes", max_code_bytes)
+		}
+		if comparator.VerdictFrom != "" && comparator.VerdictFrom != "exit_code" && comparator.VerdictFrom != "stdout" {
+			validator.Add(Field_Invalid_Value, field+".verdict_from", "must be exit_code or stdout")
+		}
+	default:
+		validator.Add(Field_Invalid_Value, field+".type", "unknown comparator %q", comparator.Type)
+	}
+	return nil
+}
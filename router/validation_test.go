@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Every language is published to Redis but "cobol", c and cpp are judged by
+// the workers of q-c and python by the ones of q-python.
+func Fake_Supported_Language(t *testing.T) {
+	original := supported_language
+	supported_language = func(name string) (bool, error) {
+		return name != "" && name != "cobol", nil
+	}
+	t.Cleanup(func() { supported_language = original })
+}
+
+var test_language_queues = map[string]string{"c": "q-c", "cpp": "q-c", "python": "q-python"}
+
+func TestValidateSubmission(t *testing.T) {
+	Fake_Supported_Language(t)
+	large_code := strings.Repeat("A", (max_code_bytes/3+1)*4)
+	tests := []struct {
+		name string
+		body string
+		// Field of every expected error, with its code
+		want []Field_Error
+	}{
+		{
+			name: "legacy accepted",
+			body: `{"language":"c","code":"aW50IG1haW4oKXt9","input":["MQ=="],"test_cases":["Mg=="],
+				"configuration":[{"time_limit":1,"memory_limit":64,"comparator":{"type":"token"}}]}`,
+		},
+		{
+			name: "v2 accepted",
+			body: `{"language":"c","code":"aW50IG1haW4oKXt9","tests":[
+				{"stdin":"MQ==","expected":"Mg==","limits":{"time_limit":1,"memory_limit":64,"cpus":0.5},"group":"a","points":10},
+				{"stdin":"","expected":"","limits":{},"comparator":{"type":"float","absolute_epsilon":1e-6}}],
+				"groups":[{"id":"a","scoring":"proportional"}]}`,
+		},
+		{
+			name: "language and code required",
+			body: `{"input":["MQ=="],"test_cases":["Mg=="],"configuration":[{}]}`,
+			want: []Field_Error{{Code: Field_Required, Field: "language"}, {Code: Field_Required, Field: "code"}},
+		},
+		{
+			name: "unsupported language",
+			body: `{"language":"cobol","code":"YQ==","input":["MQ=="],"test_cases":["Mg=="],"configuration":[{}]}`,
+			want: []Field_Error{{Code: Field_Unsupported_Language, Field: "language"}},
+		},
+		{
+			name: "code not base64 or too large",
+			body: `{"language":"c","code":"` + large_code + `","tests":[{"stdin":"%%%","expected":""}]}`,
+			want: []Field_Error{{Code: Field_Too_Large, Field: "code"}, {Code: Field_Invalid_Base64, Field: "tests[0].stdin"}},
+		},
+		{
+			name: "legacy arrays of different lengths",
+			body: `{"language":"c","code":"YQ==","input":["MQ==","Mg=="],"test_cases":["Mg=="],"replace":[[]],"configuration":[{}]}`,
+			want: []Field_Error{
+				{Code: Field_Length_Mismatch, Field: "test_cases"},
+				{Code: Field_Length_Mismatch, Field: "replace"},
+				{Code: Field_Length_Mismatch, Field: "configuration"},
+			},
+		},
+		{
+			name: "legacy without test cases",
+			body: `{"language":"c","code":"YQ=="}`,
+			want: []Field_Error{{Code: Field_Required, Field: "input"}},
+		},
+		{
+			name: "legacy with groups",
+			body: `{"language":"c","code":"YQ==","input":["MQ=="],"test_cases":["Mg=="],"configuration":[{}],"groups":[{"id":"a"}]}`,
+			want: []Field_Error{{Code: Field_Invalid_Value, Field: "groups"}},
+		},
+		{
+			name: "tests combined with the legacy arrays",
+			body: `{"language":"c","code":"YQ==","input":["MQ=="],"tests":[{"stdin":"","expected":""}]}`,
+			want: []Field_Error{{Code: Field_Invalid_Value, Field: "tests"}},
+		},
+		{
+			name: "limits out of range",
+			body: `{"language":"c","code":"YQ==","tests":[{"stdin":"","expected":"","points":-1,
+				"limits":{"time_limit":31,"memory_limit":5,"cpu_time_limit":-1,"cpus":5}}]}`,
+			want: []Field_Error{
+				{Code: Field_Out_Of_Range, Field: "tests[0].limits.memory_limit"},
+				{Code: Field_Out_Of_Range, Field: "tests[0].limits.time_limit"},
+				{Code: Field_Out_Of_Range, Field: "tests[0].limits.cpu_time_limit"},
+				{Code: Field_Out_Of_Range, Field: "tests[0].limits.cpus"},
+				{Code: Field_Out_Of_Range, Field: "tests[0].points"},
+			},
+		},
+		{
+			name: "legacy limits out of range",
+			body: `{"language":"c","code":"YQ==","input":["MQ=="],"test_cases":["Mg=="],"configuration":[{"time_limit":-1,"memory_limit":2048}]}`,
+			want: []Field_Error{
+				{Code: Field_Out_Of_Range, Field: "configuration[0].memory_limit"},
+				{Code: Field_Out_Of_Range, Field: "configuration[0].time_limit"},
+			},
+		},
+		{
+			name: "invalid comparators",
+			body: `{"language":"c","code":"YQ==","tests":[
+				{"stdin":"","expected":"","comparator":{"type":"regex"}},
+				{"stdin":"","expected":"","comparator":{"type":"float","absolute_epsilon":-1,"relative_epsilon":-1}}]}`,
+			want: []Field_Error{
+				{Code: Field_Invalid_Value, Field: "tests[0].comparator.type"},
+				{Code: Field_Out_Of_Range, Field: "tests[1].comparator.absolute_epsilon"},
+				{Code: Field_Out_Of_Range, Field: "tests[1].comparator.relative_epsilon"},
+			},
+		},
+		{
+			name: "checker program on the queue of the submission",
+			body: `{"language":"c","code":"YQ==","tests":[{"stdin":"","expected":"",
+				"comparator":{"type":"program","language":"cpp","code":"YQ==","verdict_from":"stdout"}}]}`,
+		},
+		{
+			name: "checker program on another queue",
+			body: `{"language":"c","code":"YQ==","tests":[{"stdin":"","expected":"",
+				"comparator":{"type":"program","language":"python","code":"","verdict_from":"stderr"}}]}`,
+			want: []Field_Error{
+				{Code: Field_Unsupported_Language, Field: "tests[0].comparator.language"},
+				{Code: Field_Required, Field: "tests[0].comparator.code"},
+				{Code: Field_Invalid_Value, Field: "tests[0].comparator.verdict_from"},
+			},
+		},
+		{
+			name: "interactor on another queue",
+			body: `{"language":"python","code":"YQ==","tests":[{"stdin":"","expected":""}],
+				"interactor":{"language":"c","code":"YQ==","query_limit":-1}}`,
+			want: []Field_Error{
+				{Code: Field_Unsupported_Language, Field: "interactor.language"},
+				{Code: Field_Out_Of_Range, Field: "interactor.query_limit"},
+			},
+		},
+		{
+			name: "invalid groups",
+			body: `{"language":"c","code":"YQ==","tests":[{"stdin":"","expected":""}],
+				"groups":[{"id":"a"},{"id":"a","scoring":"best"},{"points":-1}]}`,
+			want: []Field_Error{
+				{Code: Field_Invalid_Value, Field: "groups[1].id"},
+				{Code: Field_Invalid_Value, Field: "groups[1].scoring"},
+				{Code: Field_Required, Field: "groups[2].id"},
+				{Code: Field_Out_Of_Range, Field: "groups[2].points"},
+			},
+		},
+		{
+			name: "callback to an internal address",
+			body: `{"language":"c","code":"YQ==","tests":[{"stdin":"","expected":""}],"callback_url":"http://127.0.0.1:8080/hook"}`,
+			want: []Field_Error{{Code: Field_Invalid_Value, Field: "callback_url"}},
+		},
+		{
+			name: "callback that is not http",
+			body: `{"language":"c","code":"YQ==","tests":[{"stdin":"","expected":""}],"callback_url":"file:///etc/passwd"}`,
+			want: []Field_Error{{Code: Field_Invalid_Value, Field: "callback_url"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			submission := &Submission{}
+			if err := json.Unmarshal([]byte(test.body), submission); err != nil {
+				t.Fatalf("invalid test body: %v", err)
+			}
+			field_errors, err := Validate_Submission(submission, test_language_queues)
+			if err != nil {
+				t.Fatalf("Validate_Submission() error = %v", err)
+			}
+			got := []Field_Error{}
+			for _, field_error := range field_errors {
+				got = append(got, Field_Error{Code: field_error.Code, Field: field_error.Field})
+			}
+			want := test.want
+			if want == nil {
+				want = []Field_Error{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Validate_Submission() = %+v, want %+v", field_errors, want)
+			}
+		})
+	}
+}
+
+func TestValidateSubmissionLookupError(t *testing.T) {
+	original := supported_language
+	supported_language = func(name string) (bool, error) {
+		return false, errors.New("redis is down")
+	}
+	defer func() { supported_language = original }()
+
+	submission := &Submission{Language: "c", Code: "YQ=="}
+	if _, err := Validate_Submission(submission, test_language_queues); err == nil {
+		t.Errorf("Validate_Submission() error = nil, want the lookup error")
+	}
+}